@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/validator"
+)
+
+// The maximum number of operations accepted in a single batch request.
+const maxBatchOperations = 100
+
+// errBatchAborted is returned from inside the batch transaction when one of the
+// operations fails, so that the whole transaction is rolled back.
+var errBatchAborted = errors.New("batch aborted")
+
+// batchOperation is a single create, update or delete in a batch request. Update and
+// delete operations identify the movie by ID, and updates must also carry the version
+// the client expects the movie to be at, just like updateMovieHandler.
+type batchOperation struct {
+	Op      string `json:"op"`
+	ID      int64  `json:"id"`
+	Version *int32 `json:"version"`
+	Movie   struct {
+		Title   *string       `json:"title"`
+		Year    *int32        `json:"year"`
+		Runtime *data.Runtime `json:"runtime"`
+		Genres  []string      `json:"genres"`
+	} `json:"movie"`
+}
+
+// batchResult is the outcome of a single operation. If any operation fails, the
+// operations before it are reported as "rolled_back" and those after it as "skipped".
+type batchResult struct {
+	Index  int               `json:"index"`
+	Op     string            `json:"op"`
+	Status string            `json:"status"`
+	Movie  *data.Movie       `json:"movie,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// The batchMovieHandler handles "POST /v1/movies/batch". All of the operations are run
+// in one transaction, so either they all succeed or none of them are applied.
+func (app *application) batchMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Operations) >= 1, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations",
+		fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+
+	for i, op := range input.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+
+		v.Check(validator.In(op.Op, "create", "update", "delete"), key+".op", "must be create, update or delete")
+
+		if op.Op == "update" || op.Op == "delete" {
+			v.Check(op.ID > 0, key+".id", "must be provided")
+		}
+		if op.Op == "update" {
+			v.Check(op.Version != nil, key+".version", "must be provided")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]*batchResult, len(input.Operations))
+	for i, op := range input.Operations {
+		results[i] = &batchResult{Index: i, Op: op.Op, Status: "skipped"}
+	}
+
+	// failed holds the HTTP status code for the first operation that failed.
+	failed := 0
+
+	err = app.models.Movies.WithActor(app.actor(r)).Batch(r.Context(), func(tx data.MovieModel) error {
+		for i, op := range input.Operations {
+			status, err := app.applyBatchOperation(r.Context(), tx, op, results[i])
+			if err != nil {
+				return err
+			}
+
+			if status != http.StatusOK {
+				failed = status
+				return errBatchAborted
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchAborted) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if failed != 0 {
+		// Nothing was committed, so strip the movie data from the operations that ran
+		// before the failure and mark them as rolled back.
+		for _, result := range results {
+			if result.Status == "ok" {
+				result.Status = "rolled_back"
+				result.Movie = nil
+			}
+		}
+
+		app.errorResponse(w, r, failed, envelope{
+			"message": "the batch was not applied because one of its operations failed",
+			"results": results,
+		})
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// applyBatchOperation runs a single operation against the transaction and records the
+// outcome in result. The returned status code is http.StatusOK if the operation
+// succeeded, or the status code that describes why it failed. A non-nil error is only
+// returned for unexpected database problems.
+func (app *application) applyBatchOperation(ctx context.Context, tx data.MovieModel, op batchOperation, result *batchResult) (int, error) {
+	switch op.Op {
+	case "create":
+		movie := &data.Movie{}
+		applyMovieInput(movie, op)
+
+		if status := validateBatchMovie(movie, result); status != http.StatusOK {
+			return status, nil
+		}
+
+		err := tx.Insert(ctx, movie)
+		if err != nil {
+			return 0, err
+		}
+
+		result.Status = "ok"
+		result.Movie = movie
+
+	case "update":
+		movie, err := tx.Get(ctx, op.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				result.Status = "not_found"
+				return http.StatusNotFound, nil
+			default:
+				return 0, err
+			}
+		}
+
+		// Check the version the client expects up front, so that an out-of-date update
+		// is reported as a conflict rather than as a validation failure.
+		if movie.Version != *op.Version {
+			result.Status = "edit_conflict"
+			result.Errors = map[string]string{"version": data.ErrEditConflict.Error()}
+			return http.StatusConflict, nil
+		}
+
+		applyMovieInput(movie, op)
+
+		if status := validateBatchMovie(movie, result); status != http.StatusOK {
+			return status, nil
+		}
+
+		err = tx.Update(ctx, movie)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				result.Status = "edit_conflict"
+				result.Errors = map[string]string{"version": data.ErrEditConflict.Error()}
+				return http.StatusConflict, nil
+			default:
+				return 0, err
+			}
+		}
+
+		result.Status = "ok"
+		result.Movie = movie
+
+	case "delete":
+		err := tx.Delete(ctx, op.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				result.Status = "not_found"
+				return http.StatusNotFound, nil
+			default:
+				return 0, err
+			}
+		}
+
+		result.Status = "ok"
+	}
+
+	return http.StatusOK, nil
+}
+
+// applyMovieInput copies the fields that were provided in the operation onto movie,
+// leaving the others unchanged.
+func applyMovieInput(movie *data.Movie, op batchOperation) {
+	if op.Movie.Title != nil {
+		movie.Title = *op.Movie.Title
+	}
+	if op.Movie.Year != nil {
+		movie.Year = *op.Movie.Year
+	}
+	if op.Movie.Runtime != nil {
+		movie.Runtime = *op.Movie.Runtime
+	}
+	if op.Movie.Genres != nil {
+		movie.Genres = op.Movie.Genres
+	}
+}
+
+// validateBatchMovie runs ValidateMovie() and records any failures in result.
+func validateBatchMovie(movie *data.Movie, result *batchResult) int {
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		result.Status = "invalid"
+		result.Errors = v.Errors
+		return http.StatusUnprocessableEntity
+	}
+
+	return http.StatusOK
+}
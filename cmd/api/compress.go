@@ -0,0 +1,299 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The compressors are reused between responses, since each one allocates a good deal
+// of memory for its window and tables.
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibWriters = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// compressor is implemented by *gzip.Writer and *zlib.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compress compresses response bodies with gzip or deflate, if the client accepts one
+// of them. Small responses aren't worth compressing, so a response is buffered until
+// it reaches the minimum size; if the handler finishes first, it's sent as it is.
+//
+// Streaming responses work as expected: flushing the response (as the export endpoint
+// does after each chunk) flushes the compressed stream too, so the client gets each
+// chunk straight away. Server-sent events are never compressed, because some proxies
+// and browsers buffer compressed event streams until they end.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := app.settings()
+
+		if !settings.compress.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The response depends on Accept-Encoding whether or not it ends up compressed,
+		// so caches need to know about it either way.
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			minSize:        settings.compress.minSize,
+			status:         http.StatusOK,
+		}
+		// If the handler aborts the response with a panic (see exportMovieHandler), don't
+		// finish the compressed stream, which would make a partial body look complete.
+		defer func() {
+			if err := recover(); err != nil {
+				cw.release()
+				panic(err)
+			}
+			cw.Close()
+		}()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks the content coding to use from an Accept-Encoding header:
+// "gzip", "deflate" or "" for none. The client's q-values are respected, and gzip is
+// preferred when they're equal.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		weight := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				weight = f
+			}
+		}
+		q[coding] = weight
+	}
+
+	// A wildcard stands for any coding not listed explicitly.
+	for _, coding := range []string{"gzip", "deflate"} {
+		if _, ok := q[coding]; !ok {
+			if weight, ok := q["*"]; ok {
+				q[coding] = weight
+			}
+		}
+	}
+
+	switch {
+	case q["gzip"] > 0 && q["gzip"] >= q["deflate"]:
+		return "gzip"
+	case q["deflate"] > 0:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// compressWriter is a http.ResponseWriter which holds back the status code and the
+// start of the body until it's clear whether the response should be compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status      int
+	wroteHeader bool // WriteHeader() has been called on the compressWriter.
+	decided     bool // The status and headers have been sent on.
+	buf         []byte
+	cw          compressor
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	// Informational responses go straight through, and don't count as the status.
+	if status >= 100 && status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.status = status
+	w.wroteHeader = true
+
+	// Responses without a body can't be compressed, so there's nothing to wait for. A
+	// 304 must carry the ETag which the full response would have had, though, and
+	// that's assumed to have been compressed.
+	if status == http.StatusNotModified {
+		w.codeETag()
+	}
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minSize {
+			return len(b), nil
+		}
+
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.cw != nil {
+		return w.cw.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide sends on the status and headers, and the buffered start of the body, either
+// compressed or not. The response is only compressed if the handler hasn't encoded it
+// itself and it isn't an event stream.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+
+	header := w.ResponseWriter.Header()
+
+	if compress && header.Get("Content-Encoding") == "" {
+		mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		compress = mediaType != "text/event-stream"
+	} else {
+		compress = false
+	}
+
+	if compress {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		w.codeETag()
+
+		if w.encoding == "gzip" {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(w.ResponseWriter)
+			w.cw = gz
+		} else {
+			zw := zlibWriters.Get().(*zlib.Writer)
+			zw.Reset(w.ResponseWriter)
+			w.cw = zw
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.cw != nil {
+		_, err := w.cw.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// codeETag adds the content coding to a strong ETag, so that "5" becomes "5-gzip". A
+// strong ETag promises byte-for-byte identical bodies, which the compressed and plain
+// responses aren't. etagMatches() removes the coding again, so a tag the client got
+// from a compressed response still works in If-Match and If-None-Match headers. Weak
+// ETags are left alone, since the two bodies are equivalent.
+func (w *compressWriter) codeETag() {
+	header := w.ResponseWriter.Header()
+
+	etag := header.Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") || !strings.HasSuffix(etag, `"`) {
+		return
+	}
+
+	header.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+w.encoding+`"`)
+}
+
+// FlushError is called by http.ResponseController.Flush(). A flush means the handler
+// is streaming, so it starts compression without waiting for the minimum size, and then
+// pushes out everything compressed so far.
+func (w *compressWriter) FlushError() error {
+	if !w.decided {
+		if err := w.decide(w.wroteHeader); err != nil {
+			return err
+		}
+	}
+
+	if w.cw != nil {
+		if err := w.cw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Flush implements http.Flusher, for handlers which use it rather than a
+// ResponseController.
+func (w *compressWriter) Flush() {
+	w.FlushError()
+}
+
+// Close sends anything still held back, and finishes the compressed stream.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		// The handler finished before the body reached the minimum size, so send it as
+		// it is.
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+
+	if w.cw == nil {
+		return nil
+	}
+
+	err := w.cw.Close()
+	w.release()
+
+	return err
+}
+
+// release returns the compressor to its pool.
+func (w *compressWriter) release() {
+	switch cw := w.cw.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(cw)
+	case *zlib.Writer:
+		zlibWriters.Put(cw)
+	}
+	w.cw = nil
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController
+// can reach its other methods, such as SetWriteDeadline().
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
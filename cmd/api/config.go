@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+	"github.com/Ramdoni007/21Cinema/internal/validator"
+)
+
+// The prefix for environment variables which set configuration values. The rest of the
+// name is the flag name in upper case with dashes replaced by underscores, so the
+// -db-dsn flag can also be set with CINEMA_DB_DSN.
+const envPrefix = "CINEMA_"
+
+// stringList is a flag.Value holding a space-separated list, such as the access log
+// skip paths. Setting it replaces the whole list rather than appending, so that a value
+// from a flag cleanly overrides one from the config file or environment.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(val string) error {
+	*l = strings.Fields(val)
+	return nil
+}
+
+// flagSet returns a FlagSet which reads every setting into cfg. Each setting has one
+// name, which is used for the flag, the config file key and the environment variable.
+func (cfg *config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+
+	// Read the path of the config file, and whether to print the effective
+	// configuration and exit rather than starting the server.
+	fs.StringVar(&cfg.file, "config", "", "Path to a JSON, YAML or TOML config file")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print the effective configuration (secrets masked) and exit")
+
+	// Read the value of the port and env command-line flags into the config struct. We
+	// default to using the port number 4000 and the environment "development" if no
+	// corresponding flags are provided.
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+
+	// Read the logging settings.
+	fs.StringVar(&cfg.log.level, "log-level", "info", "Minimum log level (debug|info|warn|error)")
+	fs.BoolVar(&cfg.log.traces, "log-traces", true, "Include stack traces in ERROR log entries")
+	fs.BoolVar(&cfg.log.stdout, "log-stdout", true, "Write log entries to stdout")
+	fs.StringVar(&cfg.log.file, "log-file", "", "Also write log entries to this file (empty to disable)")
+	fs.IntVar(&cfg.log.fileMaxSize, "log-file-max-size", 100, "Rotate the log file when it reaches this size in MB (0 to disable)")
+	fs.DurationVar(&cfg.log.fileRotate, "log-file-rotate", 24*time.Hour, "Rotate the log file at this interval (0 to disable)")
+	fs.DurationVar(&cfg.log.fileMaxAge, "log-file-max-age", 7*24*time.Hour, "Delete rotated log files older than this (0 to keep)")
+	fs.IntVar(&cfg.log.fileMaxBackups, "log-file-max-backups", 10, "Maximum number of rotated log files to keep (0 for no limit)")
+	fs.BoolVar(&cfg.log.fileCompress, "log-file-compress", true, "Gzip rotated log files")
+	fs.StringVar(&cfg.log.socket, "log-socket", "", "Also send log entries to a local socket, as network:address (e.g. unixgram:/dev/log)")
+	fs.BoolVar(&cfg.log.async, "log-async", false, "Write log entries from a background queue")
+	fs.IntVar(&cfg.log.queueSize, "log-queue-size", 4096, "Number of entries the async log queue holds before dropping")
+
+	// Read any property keys to mask in log entries, in addition to the built-in ones
+	// (passwords, tokens, emails and so on).
+	fs.Var((*stringList)(&cfg.log.redactKeys), "log-redact-keys", "Extra log property keys to redact (space separated)")
+
+	// Read the DSN value from the db-dsn command-line flag into the config struct. There
+	// is deliberately no default, so that credentials never live in the source code;
+	// for development, set CINEMA_DB_DSN in your environment.
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "Postgresql DSN")
+
+	// Read the connection pool settings from command-line flags into the config struct.
+	// Notice the default values that we're using?
+	fs.IntVar(&cfg.db.maxOpenCoons, "db-max-open-conns", 25, "Postgresql max open connections")
+	fs.IntVar(&cfg.db.maxIdleCoons, "db-max-idle-conns", 25, "Postgresql max idle connections")
+	fs.StringVar(
+		&cfg.db.maxIdleTime,
+		"db-max-idle-Time",
+		"15m",
+		"Postgresql max connections idle time",
+	)
+
+	// Create command line flags to read the setting values into the config struct.
+	// Notice that we use true as the default for the 'enabled' setting?
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum request persecond")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enabled rate limiter")
+
+	// Read the per-route rate limit overrides. By default, registering users is limited
+	// much more strictly than the rest of the API, to slow down account farming.
+	cfg.limiter.routes = routeLimits{
+		"POST /v1/users": {name: "POST /v1/users", rps: 0.1, burst: 3},
+	}
+	fs.Var(&cfg.limiter.routes, "limiter-routes", "Per-route rate limits as METHOD:PATTERN=RPS,BURST (space separated)")
+
+	// Read where the rate limit counts are kept. Use postgres when running more than one
+	// instance of the API, so that they share the counts.
+	fs.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Rate limiter store (memory|postgres)")
+
+	// Read the movie cache settings.
+	fs.BoolVar(&cfg.cache.enabled, "cache-enabled", true, "Cache movie reads in memory")
+	fs.IntVar(&cfg.cache.size, "cache-size", 1000, "Maximum number of cached movie reads")
+	fs.DurationVar(&cfg.cache.ttl, "cache-ttl", 30*time.Second, "How long to cache a movie read for")
+
+	// Read the response encoding settings.
+	fs.BoolVar(&cfg.compress.enabled, "compress", true, "Compress responses with gzip or deflate")
+	fs.IntVar(&cfg.compress.minSize, "compress-min-size", 1024, "Minimum response size in bytes to compress")
+	fs.StringVar(&cfg.json.indent, "json-indent", "auto", "JSON response indentation (auto|tab|none)")
+
+	// Read the request size limits.
+	fs.IntVar(&cfg.http.maxHeaderBytes, "http-max-header-bytes", 16384, "Maximum size of the request line and headers in bytes")
+	fs.IntVar(&cfg.http.maxURLLength, "http-max-url-length", 4096, "Maximum length of the request URL in bytes")
+
+	// Read the handler timeouts. The export and import stream for as long as they need
+	// to, so by default they aren't given a deadline.
+	fs.DurationVar(&cfg.timeouts.handler, "handler-timeout", 10*time.Second, "Deadline for handling a request (0 for none)")
+	cfg.timeouts.routes = routeTimeouts{
+		"GET /v1/movies/export":  0,
+		"POST /v1/movies/import": 0,
+	}
+	fs.Var(&cfg.timeouts.routes, "route-timeouts", "Per-route handler timeouts as METHOD:PATTERN=DURATION (space separated)")
+
+	// Read the TLS settings. The API server only serves HTTPS if a certificate is given;
+	// otherwise TLS is expected to be terminated in front of it, by the load balancer.
+	fs.StringVar(&cfg.tls.certFile, "tls-cert-file", "", "TLS certificate file (PEM), to serve HTTPS")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key-file", "", "TLS private key file (PEM)")
+	fs.StringVar(&cfg.tls.minVersion, "tls-min-version", "1.2", "Minimum TLS version (1.2|1.3)")
+	fs.Var((*stringList)(&cfg.tls.cipherSuites), "tls-cipher-suites", "TLS 1.2 cipher suites in order of preference (space separated, empty for Go's defaults)")
+	fs.DurationVar(&cfg.tls.reloadInterval, "tls-reload-interval", time.Minute, "How often to check the TLS certificate files for changes")
+	fs.StringVar(&cfg.tls.redirectAddr, "tls-redirect-addr", "", "Address of a plain HTTP listener which redirects to HTTPS (empty to disable)")
+
+	// Read the CORS trusted origins. None are trusted by default.
+	fs.Var((*stringList)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	// Read the trusted proxy networks. None are trusted by default, so the forwarding
+	// headers are ignored unless this is set.
+	fs.Var(&cfg.trustedProxies, "trusted-proxies", "CIDRs of trusted reverse proxies (space separated)")
+
+	// Read the address for the admin listener. An empty value disables it.
+	fs.StringVar(&cfg.admin.addr, "admin-addr", "localhost:4001", "Admin server address for metrics (empty to disable)")
+
+	// Read the tracing settings.
+	fs.StringVar(&cfg.tracing.exporter, "tracing-exporter", "none", "Trace exporter (none|stdout|otlp)")
+	fs.StringVar(
+		&cfg.tracing.otlpEndpoint,
+		"tracing-otlp-endpoint",
+		"http://localhost:4318/v1/traces",
+		"OTLP/HTTP endpoint for the otlp trace exporter",
+	)
+
+	// Read the access log settings. The skip paths are given as a space-separated list.
+	fs.BoolVar(&cfg.accessLog.enabled, "access-log", true, "Enable the access log")
+	fs.Float64Var(
+		&cfg.accessLog.sampleRate,
+		"access-log-sample",
+		1,
+		"Fraction of successful requests to include in the access log (0-1)",
+	)
+
+	cfg.accessLog.skipPaths = []string{"/v1/healthcheck"}
+	fs.Var((*stringList)(&cfg.accessLog.skipPaths), "access-log-skip", "Paths to leave out of the access log (space separated)")
+
+	return fs
+}
+
+// loadConfig reads the configuration in layers, each overriding the one before: the
+// flag defaults, then the config file (given by -config or CINEMA_CONFIG), then
+// CINEMA_* environment variables, then the command-line flags. The result is validated
+// before it's returned. The FlagSet is returned too, for printConfig().
+func loadConfig(args []string) (config, *flag.FlagSet, error) {
+	var cfg config
+	fs := cfg.flagSet()
+
+	// Parse the command line once up front, to find the config file and to report bad
+	// flags before anything else. It's parsed again at the end so the flags take
+	// precedence over the file and environment.
+	err := fs.Parse(args)
+	if err != nil {
+		return cfg, fs, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fs, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if cfg.file == "" {
+		cfg.file = os.Getenv(envPrefix + "CONFIG")
+	}
+
+	if cfg.file != "" {
+		values, err := readConfigFile(cfg.file)
+		if err != nil {
+			return cfg, fs, err
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			f := lookupSetting(fs, key)
+			if f == nil {
+				return cfg, fs, fmt.Errorf("%s: unknown setting %q", cfg.file, key)
+			}
+
+			err := fs.Set(f.Name, values[key])
+			if err != nil {
+				return cfg, fs, fmt.Errorf("%s: %s: %w", cfg.file, key, err)
+			}
+		}
+	}
+
+	var envErr error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if isMetaSetting(f.Name) || envErr != nil {
+			return
+		}
+
+		name := envName(f.Name)
+
+		if value, ok := os.LookupEnv(name); ok {
+			err := fs.Set(f.Name, value)
+			if err != nil {
+				envErr = fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	})
+
+	if envErr != nil {
+		return cfg, fs, envErr
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return cfg, fs, err
+	}
+
+	return cfg, fs, cfg.validate()
+}
+
+// isMetaSetting reports whether the flag controls loading the configuration, rather
+// than being part of it, so it can't be set from the config file or environment.
+func isMetaSetting(name string) bool {
+	return name == "config" || name == "print-config"
+}
+
+// envName returns the environment variable for a flag, e.g. CINEMA_DB_DSN for db-dsn.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// lookupSetting finds the flag for a config file key, ignoring case and treating "_"
+// as "-".
+func lookupSetting(fs *flag.FlagSet, key string) *flag.Flag {
+	key = strings.ReplaceAll(key, "_", "-")
+
+	var found *flag.Flag
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if !isMetaSetting(f.Name) && strings.EqualFold(f.Name, key) {
+			found = f
+		}
+	})
+
+	return found
+}
+
+// readConfigFile reads a JSON, YAML or TOML config file (chosen by the file extension)
+// and returns its settings as flag values. Nested sections are flattened by joining the
+// keys with "-", so these are equivalent:
+//
+//	db:
+//	  dsn: postgres://...
+//	  max-open-conns: 50
+//
+//	db-dsn: postgres://...
+//	db-max-open-conns: 50
+//
+// Lists are joined with spaces, to match the list flags.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		// Decode numbers as json.Number, so that large integers aren't converted to
+		// float64 and printed in exponent form.
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file type %q (use .json, .yaml or .toml)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	flattenConfig("", raw, values)
+
+	return values, nil
+}
+
+func flattenConfig(prefix string, in map[string]any, out map[string]string) {
+	for key, value := range in {
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			flattenConfig(key, value, out)
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, " ")
+		case nil:
+			out[key] = ""
+		default:
+			out[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// validate checks the whole configuration up front, so that a bad setting is reported
+// clearly at startup rather than surfacing later as a confusing runtime error. All of
+// the problems are reported together, keyed by setting name.
+func (cfg config) validate() error {
+	v := validator.New()
+
+	v.Check(cfg.port >= 1 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	_, err := jsonlog.ParseLevel(cfg.log.level)
+	v.Check(err == nil, "log-level", "must be debug, info, warn, error, fatal or off")
+	v.Check(cfg.log.fileMaxSize >= 0, "log-file-max-size", "must not be negative")
+	v.Check(cfg.log.fileRotate >= 0, "log-file-rotate", "must not be negative")
+	v.Check(cfg.log.fileMaxAge >= 0, "log-file-max-age", "must not be negative")
+	v.Check(cfg.log.fileMaxBackups >= 0, "log-file-max-backups", "must not be negative")
+	v.Check(cfg.log.queueSize >= 1, "log-queue-size", "must be at least 1")
+	if cfg.log.socket != "" {
+		_, _, ok := strings.Cut(cfg.log.socket, ":")
+		v.Check(ok, "log-socket", "must be in the form network:address")
+	}
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided (use -db-dsn or "+envName("db-dsn")+")")
+	v.Check(cfg.db.maxOpenCoons >= 0, "db-max-open-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleCoons >= 0, "db-max-idle-conns", "must not be negative")
+	if cfg.db.maxOpenCoons > 0 {
+		v.Check(cfg.db.maxIdleCoons <= cfg.db.maxOpenCoons, "db-max-idle-conns", "must not be more than db-max-open-conns")
+	}
+	d, err := time.ParseDuration(cfg.db.maxIdleTime)
+	v.Check(err == nil && d >= 0, "db-max-idle-Time", "must be a valid duration, such as 15m")
+
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+		v.Check(cfg.limiter.burst >= 1, "limiter-burst", "must be at least 1")
+	}
+	if cfg.cache.enabled {
+		v.Check(cfg.cache.size >= 1, "cache-size", "must be at least 1")
+		v.Check(cfg.cache.ttl > 0, "cache-ttl", "must be greater than zero")
+	}
+
+	v.Check(cfg.http.maxHeaderBytes >= 4096, "http-max-header-bytes", "must be at least 4096")
+	v.Check(cfg.http.maxURLLength >= 256, "http-max-url-length", "must be at least 256")
+	v.Check(cfg.http.maxURLLength <= cfg.http.maxHeaderBytes, "http-max-url-length", "must not be more than http-max-header-bytes")
+
+	v.Check(cfg.timeouts.handler >= 0, "handler-timeout", "must not be negative")
+
+	v.Check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-key-file", "must be set together with tls-cert-file")
+	v.Check(validator.In(cfg.tls.minVersion, "1.2", "1.3"), "tls-min-version", "must be 1.2 or 1.3")
+	for _, name := range cfg.tls.cipherSuites {
+		_, ok := cipherSuiteID(name)
+		v.Check(ok, "tls-cipher-suites", fmt.Sprintf("%q is not a supported secure cipher suite", name))
+	}
+	// HTTP/2 over TLS 1.2 requires an ECDHE AES-128-GCM suite (RFC 7540, section 9.2.2),
+	// and clients drop the connection without one.
+	if len(cfg.tls.cipherSuites) > 0 && cfg.tls.minVersion == "1.2" {
+		v.Check(slices.ContainsFunc(cfg.tls.cipherSuites, isHTTP2CipherSuite), "tls-cipher-suites",
+			"must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 for HTTP/2")
+	}
+	v.Check(cfg.tls.reloadInterval > 0, "tls-reload-interval", "must be greater than zero")
+	if cfg.tls.redirectAddr != "" {
+		_, _, err := net.SplitHostPort(cfg.tls.redirectAddr)
+		v.Check(err == nil, "tls-redirect-addr", "must be a host:port address")
+		v.Check(cfg.tlsEnabled(), "tls-redirect-addr", "requires tls-cert-file to be set")
+	}
+
+	v.Check(cfg.compress.minSize >= 0, "compress-min-size", "must not be negative")
+	v.Check(validator.In(cfg.json.indent, "auto", "tab", "none"), "json-indent", "must be auto, tab or none")
+
+	v.Check(validator.In(cfg.limiter.store, "memory", "postgres"), "limiter-store", "must be memory or postgres")
+
+	if cfg.admin.addr != "" {
+		_, _, err := net.SplitHostPort(cfg.admin.addr)
+		v.Check(err == nil, "admin-addr", "must be a host:port address")
+	}
+
+	v.Check(validator.In(cfg.tracing.exporter, "none", "stdout", "otlp"), "tracing-exporter", "must be none, stdout or otlp")
+	if cfg.tracing.exporter == "otlp" {
+		u, err := url.Parse(cfg.tracing.otlpEndpoint)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"tracing-otlp-endpoint", "must be an http or https URL")
+	}
+
+	v.Check(cfg.accessLog.sampleRate >= 0 && cfg.accessLog.sampleRate <= 1, "access-log-sample", "must be between 0 and 1")
+
+	// An origin is compared with the Origin header exactly, so it must be written the
+	// way browsers send it: a scheme and host, with no path or trailing slash.
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+			u.Scheme+"://"+u.Host == origin,
+			"cors-trusted-origins", fmt.Sprintf("%q must be an origin such as https://www.example.com", origin))
+	}
+
+	if v.Valid() {
+		return nil
+	}
+
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\n  %s: %s", key, v.Errors[key])
+	}
+
+	return errors.New(b.String())
+}
+
+// The value shown in place of secrets by printConfig().
+const maskedSecret = "xxxxx"
+
+var dsnPasswordRX = regexp.MustCompile(`(?i)(password\s*=\s*)('[^']*'|\S+)`)
+
+// maskDSN hides the password in a Postgres DSN, in either URL or key=value form.
+func maskDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), maskedSecret)
+		}
+		return u.String()
+	}
+
+	return dsnPasswordRX.ReplaceAllString(dsn, "${1}"+maskedSecret)
+}
+
+// printConfig writes the effective value of every setting to w as JSON, with secrets
+// masked, so that it can be checked (or saved as a starting config file) without
+// exposing credentials.
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	redactor := jsonlog.NewRedactor()
+	values := make(map[string]string)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if isMetaSetting(f.Name) {
+			return
+		}
+
+		value := f.Value.String()
+
+		switch {
+		case f.Name == "db-dsn":
+			value = maskDSN(value)
+		case redactor.SensitiveKey(f.Name) && value != "":
+			value = maskedSecret
+		}
+
+		values[f.Name] = value
+	})
+
+	// encoding/json sorts the map keys, so the output is stable.
+	js, err := json.MarshalIndent(values, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(js, '\n'))
+	return err
+}
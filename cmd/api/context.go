@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+)
+
+// Define a custom contextKey type, with the underlying type string, so that our keys
+// can't collide with keys set by other packages.
+type contextKey string
+
+const (
+	routeContextKey     = contextKey("route")
+	requestIDContextKey = contextKey("requestID")
+	loggerContextKey    = contextKey("logger")
+	userContextKey      = contextKey("user")
+	clientIPContextKey  = contextKey("clientIP")
+)
+
+// routeInfo is placed in the request context by the outermost middleware, before the
+// router has run. The router fills in the matched pattern, so that middleware can label
+// metrics and logs by route (e.g. "/v1/movies/:id") rather than by the raw URL.
+type routeInfo struct {
+	pattern string
+}
+
+// contextSetRouteInfo returns a new copy of the request with an empty routeInfo added
+// to the context. If an outer middleware has already added one, the request is
+// returned unchanged so that both middleware share it.
+func (app *application) contextSetRouteInfo(r *http.Request) (*http.Request, *routeInfo) {
+	if info, ok := r.Context().Value(routeContextKey).(*routeInfo); ok {
+		return r, info
+	}
+
+	info := &routeInfo{}
+	ctx := context.WithValue(r.Context(), routeContextKey, info)
+	return r.WithContext(ctx), info
+}
+
+// contextGetRoutePattern returns the route pattern matched for the request, or
+// "unmatched" if the router didn't match one (for example for a 404).
+func (app *application) contextGetRoutePattern(r *http.Request) string {
+	info, ok := r.Context().Value(routeContextKey).(*routeInfo)
+	if !ok || info.pattern == "" {
+		return "unmatched"
+	}
+	return info.pattern
+}
+
+// contextSetRoutePattern records the route pattern matched for the request, if the
+// request passed through contextSetRouteInfo().
+func (app *application) contextSetRoutePattern(r *http.Request, pattern string) {
+	if info, ok := r.Context().Value(routeContextKey).(*routeInfo); ok {
+		info.pattern = pattern
+	}
+}
+
+// contextSetRoute records the matched route pattern for the request and adds it to the
+// request-scoped logger, returning the updated request. If no middleware has added a
+// routeInfo to the context, one is added here, so that handlers can always rely on
+// contextGetRoutePattern().
+func (app *application) contextSetRoute(r *http.Request, pattern string) *http.Request {
+	r, info := app.contextSetRouteInfo(r)
+	info.pattern = pattern
+
+	return app.contextSetLogger(r, app.contextGetLogger(r).With(map[string]any{
+		"route": pattern,
+	}))
+}
+
+// contextSetRequestID returns a new copy of the request with the request ID added to
+// the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the ID of the request, or the empty string if the
+// request didn't pass through the requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// contextSetLogger returns a new copy of the request with a request-scoped logger
+// added to the context.
+func (app *application) contextSetLogger(r *http.Request, logger *jsonlog.Logger) *http.Request {
+	ctx := context.WithValue(r.Context(), loggerContextKey, logger)
+	return r.WithContext(ctx)
+}
+
+// contextGetLogger returns the request-scoped logger, falling back to the application
+// logger for requests which didn't pass through the requestID middleware.
+func (app *application) contextGetLogger(r *http.Request) *jsonlog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey).(*jsonlog.Logger)
+	if !ok {
+		return app.logger
+	}
+	return logger
+}
+
+// contextSetUser returns a new copy of the request with the authenticated user added
+// to the context, and adds the user's ID to the request-scoped logger. There's no
+// authentication middleware yet, so nothing calls this; it's the hook for when there
+// is.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	r = r.WithContext(ctx)
+
+	return app.contextSetLogger(r, app.contextGetLogger(r).With(map[string]any{
+		"user_id": user.ID,
+	}))
+}
+
+// contextGetUser returns the authenticated user for the request, or nil if the request
+// is anonymous.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, _ := r.Context().Value(userContextKey).(*data.User)
+	return user
+}
+
+// contextSetClientIP returns a new copy of the request with the client's IP address
+// added to the context.
+func (app *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+// contextGetClientIP returns the client's IP address, or the empty string if the
+// request didn't pass through the realIP middleware.
+func (app *application) contextGetClientIP(r *http.Request) string {
+	ip, _ := r.Context().Value(clientIPContextKey).(string)
+	return ip
+}
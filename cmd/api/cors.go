@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// enableCORS lets browser applications served from the trusted origins call the API.
+// Responses to requests from a trusted origin carry an Access-Control-Allow-Origin
+// header naming it; requests from any other origin are served as normal, but without
+// the header, so the browser won't let the page read the response.
+//
+// Browsers send a preflight request (an OPTIONS request with an
+// Access-Control-Request-Method header) before any cross-origin request which isn't
+// "simple", such as a PATCH or DELETE, or a POST with a JSON body or an Authorization
+// header. Preflight requests from trusted origins are answered here, without reaching
+// the router.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Origin header (and for preflight requests, on
+		// the Access-Control-Request-Method header), so caches must not serve a
+		// response made for one origin to another.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		// The trusted origins can be changed by a configuration reload, so read them
+		// for each request.
+		if origin == "" || !slices.Contains(app.settings().cors.trustedOrigins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, POST, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match")
+			// Let the browser cache the preflight response for a minute.
+			w.Header().Set("Access-Control-Max-Age", "60")
+
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Let the page read the response headers it needs for conditional requests and
+		// to back off when rate limited.
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join([]string{
+			"ETag",
+			"Retry-After",
+			"RateLimit-Limit",
+			"RateLimit-Remaining",
+			"RateLimit-Reset",
+			"X-Request-ID",
+		}, ", "))
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
 )
 
 // The logError() method is a generic helper for logging an error message. Later in
@@ -10,8 +14,9 @@ import (
 // about the request including the HTTP method and URL.
 func (app *application) logError(r *http.Request, err error) {
 	// Use the PrintError() method to log the error message, and include the current
-	// request method and URL as properties in the log entry.
-	app.logger.PrintError(err, map[string]string{
+	// request method and URL as properties in the log entry. The request-scoped logger
+	// adds the request ID, route, client IP and trace id.
+	app.contextGetLogger(r).PrintError(err, map[string]any{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -35,6 +40,11 @@ func (app *application) errorResponse(
 ) {
 	env := envelope{"error": message}
 
+	// Include the request ID, so that clients can quote it when reporting a problem.
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
+
 	// Write the response using the writeJSON() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code
@@ -49,13 +59,44 @@ func (app *application) errorResponse(
 // unexpected problem at runtime. It logs the detailed error message, then uses the
 // errorResponse() helper to send a 500 Internal Server Error status code and JSON
 // response (containing a generic error message) to the client.
+//
+// Errors from a query which was stopped by its context are handled separately, since
+// they don't mean anything is broken. If the request's deadline passed (see timeout()),
+// the client gets a 503 Service Unavailable response; if only the query's own deadline
+// in the data layer passed, the database was too slow, and they get a 504 Gateway
+// Timeout response. If the client has gone away, there's no one to respond to, but the
+// metrics and access log record a 499 rather than the default 200.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if data.IsCanceled(err) {
+		app.canceledResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
+// canceledResponse responds to a request whose query was stopped by its context, as
+// described for serverErrorResponse().
+func (app *application) canceledResponse(w http.ResponseWriter, r *http.Request, err error) {
+	logger := app.contextGetLogger(r)
+	props := map[string]any{"error": err}
+
+	switch {
+	case errors.Is(r.Context().Err(), context.Canceled):
+		logger.PrintDebug("client closed request", props)
+		recordStatus(w, statusClientClosedRequest)
+	case errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		logger.PrintWarn("request timed out", props)
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "the server took too long to process your request, please try again later")
+	default:
+		logger.PrintWarn("database query timed out", props)
+		app.errorResponse(w, r, http.StatusGatewayTimeout, "the database took too long to respond, please try again later")
+	}
+}
+
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
 // JSON response to the client.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
@@ -86,3 +127,25 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	message := "Unable to Update the record due to an Edit Conflict please Try Again..."
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+// The preconditionFailedResponse() method will be used to send a 412 Precondition
+// Failed status code and JSON response to the client, when the If-Match header doesn't
+// match the current version of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been changed since you fetched it, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// The unsupportedMediaTypeResponse() method will be used to send a 415 Unsupported Media
+// Type status code and JSON response to the client, when the request body isn't JSON.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request body must be JSON, with the Content-Type application/json"
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The uriTooLongResponse() method will be used to send a 414 URI Too Long status code
+// and JSON response to the client.
+func (app *application) uriTooLongResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the request URL must not be longer than %d bytes", app.config.http.maxURLLength)
+	app.errorResponse(w, r, http.StatusRequestURITooLong, message)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+)
+
+// errPreconditionFailed is returned from inside a transaction when the If-Match header
+// doesn't match the current version of the movie, to roll it back.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// movieETag returns the entity tag for a movie. The version number is incremented on
+// every change, so it identifies the state of the movie exactly and makes a strong
+// ETag.
+func movieETag(movie *data.Movie) string {
+	return `"` + strconv.FormatInt(int64(movie.Version), 10) + `"`
+}
+
+// movieListETag returns a weak entity tag for a page of movies, made from a hash of the
+// IDs and versions of the movies on it and the pagination metadata. Two responses with
+// the same tag hold the same movies in the same state, but they aren't guaranteed to be
+// byte-for-byte identical, hence the tag is weak.
+func movieListETag(movies []*data.Movie, metadata data.Metadata) string {
+	h := sha256.New()
+
+	for _, movie := range movies {
+		binary.Write(h, binary.BigEndian, movie.ID)
+		binary.Write(h, binary.BigEndian, movie.Version)
+	}
+
+	for _, n := range []int{
+		metadata.CurrentPage,
+		metadata.PageSize,
+		metadata.FirstPage,
+		metadata.LastPage,
+		metadata.TotalRecords,
+	} {
+		binary.Write(h, binary.BigEndian, int64(n))
+	}
+
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether etag matches any of the entity tags in the value of an
+// If-Match or If-None-Match header (RFC 9110, section 13.1). The "*" value matches any
+// current representation. If-Match uses the strong comparison, under which a weak tag
+// never matches, while If-None-Match uses the weak comparison, which ignores the W/
+// prefix on either side. The content coding which compress() adds to strong tags, as in
+// "5-gzip", is ignored.
+func etagMatches(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	etagWeak := strings.HasPrefix(etag, "W/")
+	if etagWeak && !weak {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+
+		for _, coding := range []string{"gzip", "deflate"} {
+			if strings.HasSuffix(tag, "-"+coding+`"`) {
+				tag = strings.TrimSuffix(tag, "-"+coding+`"`) + `"`
+				break
+			}
+		}
+
+		if tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified sets the ETag header, and if the request's If-None-Match header matches
+// it, sends a 304 Not Modified response and returns true.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	if header := conditionHeader(r, "If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	return false
+}
+
+// conditionHeader returns the value of an If-Match or If-None-Match header. Both are
+// lists, which the client may split across several header lines.
+func conditionHeader(r *http.Request, name string) string {
+	return strings.Join(r.Header.Values(name), ", ")
+}
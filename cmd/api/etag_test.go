@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{"same strong tag", `"5"`, `"5"`, false, true},
+		{"other version", `"4"`, `"5"`, false, false},
+		{"one of a list", `"3", "5"`, `"5"`, false, true},
+		{"star", `*`, `"5"`, false, true},
+		{"weak tag in If-Match", `W/"5"`, `"5"`, false, false},
+		{"weak tag in If-None-Match", `W/"5"`, `"5"`, true, true},
+		{"weak current tag in If-Match", `W/"abc"`, `W/"abc"`, false, false},
+		{"weak current tag in If-None-Match", `"abc"`, `W/"abc"`, true, true},
+		{"gzip coded tag", `"5-gzip"`, `"5"`, false, true},
+		{"deflate coded tag", `"5-deflate"`, `"5"`, true, true},
+		{"coded tag of another version", `"4-gzip"`, `"5"`, false, false},
+		{"unknown coding", `"5-br"`, `"5"`, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, tt.etag, tt.weak); got != tt.want {
+				t.Errorf("etagMatches(%q, %q, %t) = %t; want %t", tt.header, tt.etag, tt.weak, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressedETag(t *testing.T) {
+	tests := []struct {
+		name           string
+		etag           string
+		status         int
+		body           string
+		acceptEncoding string
+		wantETag       string
+	}{
+		{"compressed", `"5"`, http.StatusOK, strings.Repeat("x", 2048), "gzip", `"5-gzip"`},
+		{"deflate", `"5"`, http.StatusOK, strings.Repeat("x", 2048), "deflate", `"5-deflate"`},
+		{"too small to compress", `"5"`, http.StatusOK, "x", "gzip", `"5"`},
+		{"not accepted", `"5"`, http.StatusOK, strings.Repeat("x", 2048), "", `"5"`},
+		{"weak", `W/"abc"`, http.StatusOK, strings.Repeat("x", 2048), "gzip", `W/"abc"`},
+		{"not modified", `"5"`, http.StatusNotModified, "", "gzip", `"5-gzip"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, nil)
+
+			handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", tt.etag)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			res := serve(handler, r)
+
+			if got := res.Header.Get("ETag"); got != tt.wantETag {
+				t.Errorf("got ETag %s; want %s", got, tt.wantETag)
+			}
+		})
+	}
+}
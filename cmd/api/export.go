@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/validator"
+)
+
+const (
+	// Flush the response to the client after this many rows have been written.
+	exportFlushEvery = 500
+
+	// Every flush pushes the write deadline this far into the future, so a large
+	// export isn't cut off by the server-wide WriteTimeout.
+	exportWriteWindow = 30 * time.Second
+)
+
+// sentWriter records whether anything has been written through it. The encoders write
+// to the client through a bufio.Writer, which passes data on whenever its buffer fills
+// up as well as when it's flushed, so this is the only reliable way to tell whether
+// the response has started.
+type sentWriter struct {
+	w    http.ResponseWriter
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.w.Write(p)
+}
+
+// movieEncoder writes movies to an export stream in a particular format.
+type movieEncoder interface {
+	Encode(*data.Movie) error
+	Flush() error
+}
+
+// ndjsonMovieEncoder writes one JSON-encoded movie per line.
+type ndjsonMovieEncoder struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONMovieEncoder(w *bufio.Writer) *ndjsonMovieEncoder {
+	return &ndjsonMovieEncoder{buf: w, enc: json.NewEncoder(w)}
+}
+
+func (e *ndjsonMovieEncoder) Encode(movie *data.Movie) error {
+	return e.enc.Encode(movie)
+}
+
+func (e *ndjsonMovieEncoder) Flush() error {
+	return e.buf.Flush()
+}
+
+// csvMovieEncoder writes movies as CSV records, using the same columns that the
+// import endpoint accepts (so an export can be fed straight back in).
+type csvMovieEncoder struct {
+	buf *bufio.Writer
+	csv *csv.Writer
+}
+
+func newCSVMovieEncoder(w *bufio.Writer) (*csvMovieEncoder, error) {
+	e := &csvMovieEncoder{buf: w, csv: csv.NewWriter(w)}
+
+	err := e.csv.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *csvMovieEncoder) Encode(movie *data.Movie) error {
+	return e.csv.Write([]string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		strconv.FormatInt(int64(movie.Runtime), 10),
+		strings.Join(movie.Genres, "|"),
+		strconv.FormatInt(int64(movie.Version), 10),
+	})
+}
+
+func (e *csvMovieEncoder) Flush() error {
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return err
+	}
+	return e.buf.Flush()
+}
+
+// The exportMovieHandler handles "GET /v1/movies/export". It accepts the same title,
+// genres and sort query string parameters as listMovieHandler (but no pagination), and
+// a format parameter of either "ndjson" (the default) or "csv". The rows are streamed
+// straight from a database cursor to the client.
+func (app *application) exportMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Genres []string
+		Format string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Format = app.readString(qs, "format", "ndjson")
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortsafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	// Pagination doesn't apply to an export, so only the sort value is checked here.
+	v.Check(validator.In(input.Filters.Sort, input.Filters.SortsafeList...), "sort", "invalid sort value")
+	v.Check(validator.In(input.Format, "ndjson", "csv"), "format", "must be either ndjson or csv")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	sw := &sentWriter{w: w}
+	buf := bufio.NewWriter(sw)
+
+	var enc movieEncoder
+
+	switch input.Format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+
+		csvEnc, err := newCSVMovieEncoder(buf)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		enc = csvEnc
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc = newNDJSONMovieEncoder(buf)
+	}
+
+	// flush pushes everything buffered so far to the client and extends the write
+	// deadline for the next chunk. Not every ResponseWriter supports deadlines, so an
+	// http.ErrNotSupported error from SetWriteDeadline() is ignored.
+	flush := func() error {
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+
+		err := rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+		if err != nil && err != http.ErrNotSupported {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	// Set the first deadline before anything is written, so that the time spent
+	// opening the cursor doesn't count against the server-wide WriteTimeout.
+	err := rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+	if err != nil && err != http.ErrNotSupported {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	written := 0
+
+	err = app.models.Movies.Export(r.Context(), input.Title, input.Genres, input.Filters, func(movie *data.Movie) error {
+		if err := enc.Encode(movie); err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushEvery == 0 {
+			return flush()
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	if err != nil {
+		// If nothing has been sent yet we can still return a proper error response,
+		// dropping whatever is left in the buffer. Otherwise the status code and part of
+		// the body have already gone out, so we log the problem and abort the response.
+		// That closes the connection without ending the body properly, so the client
+		// sees an error rather than mistaking a partial export for a complete one.
+		if !sw.sent {
+			w.Header().Del("Content-Disposition")
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
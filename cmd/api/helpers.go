@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -36,6 +37,47 @@ func (app *application) readIDParams(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// Retrieve the "version" URL parameter from the current request context, in the same
+// way as readIDParams() does for the "id" parameter.
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
+// The actor() helper returns a description of who is making the request, which is
+// recorded against changes in audit trails such as the movie revision history. That's
+// "user:<id>" for an authenticated user (see contextGetUser()), and the client's IP
+// address for anyone else.
+func (app *application) actor(r *http.Request) string {
+	if user := app.contextGetUser(r); user != nil {
+		return "user:" + strconv.FormatInt(user.ID, 10)
+	}
+
+	return app.clientIP(r)
+}
+
+// The clientIP() helper returns the client's IP address, as worked out by the realIP
+// middleware, falling back to the connection's remote address for requests which
+// didn't pass through it.
+func (app *application) clientIP(r *http.Request) string {
+	if ip := app.contextGetClientIP(r); ip != "" {
+		return ip
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
 type envelope map[string]interface{}
 
 // Define a writeJSON() helper for sending responses. This takes the destination
@@ -47,8 +89,16 @@ func (app *application) writeJSON(
 	data envelope,
 	headers http.Header,
 ) error {
-	// Encode the data to JSON, returning the error if there was one.
-	js, err := json.MarshalIndent(data, "", "\t")
+	// Encode the data to JSON, returning the error if there was one. Indenting makes the
+	// responses easier to read, but larger, so it's configurable.
+	var js []byte
+	var err error
+
+	if app.settings().indentJSON() {
+		js, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		js, err = json.Marshal(data)
+	}
 	if err != nil {
 		return err
 	}
@@ -73,6 +123,19 @@ func (app *application) writeJSON(
 	return nil
 }
 
+// indentJSON reports whether JSON responses should be indented, according to the
+// json-indent setting.
+func (cfg config) indentJSON() bool {
+	switch cfg.json.indent {
+	case "tab":
+		return true
+	case "none":
+		return false
+	default:
+		return cfg.env != "production"
+	}
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to 1MB
 	maxBytes := 1_048_576
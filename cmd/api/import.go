@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+	"github.com/Ramdoni007/21Cinema/internal/validator"
+)
+
+const (
+	// Imports are allowed a much larger body than the 1MB readJSON() limit, because
+	// the whole point is to send a lot of movies in one go.
+	maxImportBytes = 50 << 20
+
+	// The number of valid rows that are inserted together in one transaction.
+	importBatchSize = 100
+)
+
+// importRow holds the outcome for a single row of an import. Row numbers are
+// 1-based and count data rows only (the CSV header is not a row).
+type importRow struct {
+	Row    int               `json:"row"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importReport is the summary sent back to the client once the import has finished.
+// Error is set if the body couldn't be read to the end, in which case the report only
+// covers the rows before the problem.
+type importReport struct {
+	DryRun  bool         `json:"dry_run"`
+	Error   string       `json:"error,omitempty"`
+	Total   int          `json:"total"`
+	Valid   int          `json:"valid"`
+	Invalid int          `json:"invalid"`
+	Created int          `json:"created"`
+	Failed  int          `json:"failed"`
+	Rows    []*importRow `json:"rows"`
+}
+
+// movieImporter collects the rows of an import, validating each one as it arrives and
+// flushing valid movies to the database in batches (unless this is a dry run).
+type movieImporter struct {
+	ctx     context.Context
+	logger  *jsonlog.Logger
+	movies  data.MovieModel
+	report  *importReport
+	pending []*data.Movie
+	rows    []*importRow
+}
+
+// add validates a decoded movie and queues it for insertion. Rows which failed to
+// decode are passed in with a non-nil decodeErr and recorded as invalid straight away.
+func (imp *movieImporter) add(movie *data.Movie, decodeErr error) {
+	imp.report.Total++
+
+	row := &importRow{Row: imp.report.Total}
+	imp.report.Rows = append(imp.report.Rows, row)
+
+	if decodeErr != nil {
+		row.Status = "invalid"
+		row.Errors = map[string]string{"row": decodeErr.Error()}
+		imp.report.Invalid++
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		row.Status = "invalid"
+		row.Errors = v.Errors
+		imp.report.Invalid++
+		return
+	}
+
+	imp.report.Valid++
+	row.Status = "valid"
+
+	if imp.report.DryRun {
+		return
+	}
+
+	imp.pending = append(imp.pending, movie)
+	imp.rows = append(imp.rows, row)
+
+	if len(imp.pending) >= importBatchSize {
+		imp.flush()
+	}
+}
+
+// flush inserts the pending movies in a single transaction. If the transaction fails
+// then every row in the batch is marked as failed, but the import carries on with the
+// next batch.
+func (imp *movieImporter) flush() {
+	if len(imp.pending) == 0 {
+		return
+	}
+
+	err := imp.movies.InsertBatch(imp.ctx, imp.pending)
+
+	for i, row := range imp.rows {
+		if err != nil {
+			row.Status = "failed"
+			row.Errors = map[string]string{"row": "batch could not be inserted"}
+			imp.report.Failed++
+			continue
+		}
+
+		row.Status = "created"
+		row.ID = imp.pending[i].ID
+		imp.report.Created++
+	}
+
+	if err != nil {
+		imp.logger.PrintError(err, map[string]any{
+			"import_batch_size": len(imp.pending),
+		})
+	}
+
+	imp.pending = nil
+	imp.rows = nil
+}
+
+// The importMovieHandler handles "POST /v1/movies/import". The body is either a JSON
+// array of movies (in the same shape as createMovieHandler accepts) or a CSV file with
+// a title,year,runtime,genres header. Every row is validated and the valid ones are
+// inserted in batches. Passing dry_run=true in the query string validates the rows
+// without writing anything to the database.
+//
+// Each batch is committed as soon as it's full, so an import isn't all or nothing. If
+// the body turns out to be malformed or too large partway through, the rows read
+// before that are still inserted, and the batches already committed stay committed.
+// The client is told which rows were created in every case: with a 400 Bad Request
+// response if nothing was created, so the whole import can simply be sent again, and
+// otherwise with a 200 OK response whose report has the error set, so the client knows
+// to send only the rows after the created ones.
+func (app *application) importMovieHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	dryRun, err := strconv.ParseBool(app.readString(qs, "dry_run", "false"))
+	if err != nil {
+		v := validator.New()
+		v.AddError("dry_run", "must be a boolean value")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	imp := &movieImporter{
+		ctx:    r.Context(),
+		logger: app.contextGetLogger(r),
+		movies: app.models.Movies.WithActor(app.actor(r)),
+		report: &importReport{DryRun: dryRun, Rows: []*importRow{}},
+	}
+
+	// Pick the decoder based on the Content-Type header, defaulting to JSON.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "text/csv":
+		err = app.readMovieCSV(r.Body, imp.add)
+	case "", "application/json":
+		err = app.readMovieJSONArray(r.Body, imp.add)
+	default:
+		app.badRequestResponse(w, r, fmt.Errorf("unsupported content type %q", mediaType))
+		return
+	}
+
+	// Whatever happened to the stream, make sure the last partial batch is written for
+	// the rows we did manage to read.
+	imp.flush()
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("body must not be larger than %d bytes", maxImportBytes)
+		}
+
+		if imp.report.Created == 0 {
+			app.errorResponse(w, r, http.StatusBadRequest, envelope{
+				"message": err.Error(),
+				"report":  imp.report,
+			})
+			return
+		}
+
+		imp.report.Error = err.Error()
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": imp.report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importMovie is the shape of a single movie in a JSON import. It mirrors the input
+// struct used by createMovieHandler.
+type importMovie struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+// readMovieJSONArray streams a JSON array, decoding one element at a time so that the
+// whole body never has to be held in memory. Elements which fail to decode are passed
+// to fn with an error, but a malformed array stops the import.
+func (app *application) readMovieJSONArray(body io.Reader, fn func(*data.Movie, error)) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	tok, err := dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("body must not be empty")
+		}
+		return err
+	}
+
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return errors.New("body must be a JSON array of movies")
+	}
+
+	for dec.More() {
+		var input importMovie
+
+		err := dec.Decode(&input)
+		if err != nil {
+			var syntaxError *json.SyntaxError
+			var maxBytesError *http.MaxBytesError
+
+			// Syntax errors and oversized bodies leave the decoder in a state it can't
+			// recover from, so we stop here. Anything else (a wrong type or an unknown
+			// field) only affects the current element.
+			switch {
+			case errors.As(err, &syntaxError):
+				return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			case errors.Is(err, io.ErrUnexpectedEOF):
+				return errors.New("body contains badly-formed JSON")
+			case errors.As(err, &maxBytesError):
+				return err
+			}
+
+			fn(nil, err)
+			continue
+		}
+
+		fn(&data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		}, nil)
+	}
+
+	// Consume the closing bracket, then make sure nothing follows the array.
+	if _, err := dec.Token(); err != nil {
+		return errors.New("body contains badly-formed JSON")
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("body must only contain a single JSON array")
+	}
+
+	return nil
+}
+
+// readMovieCSV streams a CSV body. The first record must be a header naming the title,
+// year, runtime and genres columns (in any order). Runtime may be given either as a
+// plain number of minutes or in the "<runtime> mins" format, and genres are separated
+// with a "|" character.
+func (app *application) readMovieCSV(body io.Reader, fn func(*data.Movie, error)) error {
+	cr := csv.NewReader(body)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("body must not be empty")
+		}
+		return err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("csv header must contain a %q column", name)
+		}
+	}
+
+	// Allow records to have a different number of fields to the header, so that a
+	// short row is reported against that row rather than aborting the whole import.
+	cr.FieldsPerRecord = -1
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) && errors.Is(parseError.Err, csv.ErrFieldCount) {
+				fn(nil, err)
+				continue
+			}
+			return err
+		}
+
+		fn(parseMovieRecord(record, columns))
+	}
+}
+
+// parseMovieRecord converts a single CSV record into a Movie. Only the conversion of
+// each field is checked here; the values themselves are left to ValidateMovie().
+func parseMovieRecord(record []string, columns map[string]int) (*data.Movie, error) {
+	field := func(name string) string {
+		i := columns[name]
+		if i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	movie := &data.Movie{Title: field("title")}
+
+	if s := field("year"); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return nil, errors.New("year must be an integer")
+		}
+		movie.Year = int32(year)
+	}
+
+	if s := field("runtime"); s != "" {
+		mins, err := strconv.ParseInt(strings.TrimSuffix(s, " mins"), 10, 32)
+		if err != nil {
+			return nil, data.ErrInvalidRuntimeFormat
+		}
+		movie.Runtime = data.Runtime(mins)
+	}
+
+	if s := field("genres"); s != "" {
+		for _, genre := range strings.Split(s, "|") {
+			movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+		}
+	}
+
+	return movie, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/metrics"
+)
+
+// appMetrics holds the metrics which are updated as requests are handled. The
+// database and runtime metrics are read on demand when the metrics are scraped.
+type appMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	inFlight *metrics.GaugeVec
+	duration *metrics.HistogramVec
+
+	// The expvar counters mirror the request metrics for /debug/vars. They're nil
+	// until publishExpvars() has been called.
+	totalRequestsReceived      *expvar.Int
+	totalResponsesSent         *expvar.Int
+	totalProcessingTimeMicros  *expvar.Int
+	totalResponsesSentByStatus *expvar.Map
+}
+
+// newAppMetrics creates the registry for the application, including gauges and
+// counters for the connection pool statistics of db and for the Go runtime.
+func newAppMetrics(db *sql.DB) *appMetrics {
+	reg := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry: reg,
+		requests: reg.NewCounterVec(
+			"http_requests_total",
+			"Total number of HTTP requests processed, by route and status code.",
+			"method", "route", "status",
+		),
+		inFlight: reg.NewGaugeVec(
+			"http_requests_in_flight",
+			"Number of HTTP requests currently being processed.",
+		),
+		duration: reg.NewHistogramVec(
+			"http_request_duration_seconds",
+			"Latency of HTTP requests, by route and status code.",
+			nil,
+			"method", "route", "status",
+		),
+	}
+
+	// DB.Stats() takes a lock on the pool, so each metric reads it separately at
+	// scrape time rather than keeping a copy.
+	stats := func(fn func(sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+
+	reg.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	reg.NewGaugeFunc("db_open_connections", "Number of established connections, both in use and idle.",
+		stats(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	reg.NewGaugeFunc("db_in_use_connections", "Number of connections currently in use.",
+		stats(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	reg.NewGaugeFunc("db_idle_connections", "Number of idle connections.",
+		stats(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	reg.NewCounterFunc("db_wait_count_total", "Total number of connections waited for.",
+		stats(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	reg.NewCounterFunc("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.",
+		stats(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	reg.NewCounterFunc("db_max_idle_closed_total", "Total number of connections closed due to SetMaxIdleConns.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	reg.NewCounterFunc("db_max_idle_time_closed_total", "Total number of connections closed due to SetConnMaxIdleTime.",
+		stats(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+
+	reg.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+
+	// runtime.ReadMemStats() stops the world briefly, so it's read once at the start of
+	// each scrape, and the memory metrics all take their values from that snapshot.
+	var (
+		msMu sync.Mutex
+		ms   runtime.MemStats
+	)
+	reg.BeforeScrape(func() {
+		msMu.Lock()
+		defer msMu.Unlock()
+		runtime.ReadMemStats(&ms)
+	})
+
+	memStats := func(fn func(*runtime.MemStats) float64) func() float64 {
+		return func() float64 {
+			msMu.Lock()
+			defer msMu.Unlock()
+			return fn(&ms)
+		}
+	}
+
+	reg.NewGaugeFunc("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.",
+		memStats(func(ms *runtime.MemStats) float64 { return float64(ms.Alloc) }))
+	reg.NewGaugeFunc("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.",
+		memStats(func(ms *runtime.MemStats) float64 { return float64(ms.HeapInuse) }))
+	reg.NewGaugeFunc("go_memstats_sys_bytes", "Number of bytes obtained from the system.",
+		memStats(func(ms *runtime.MemStats) float64 { return float64(ms.Sys) }))
+	reg.NewCounterFunc("go_gc_cycles_total", "Number of completed GC cycles.",
+		memStats(func(ms *runtime.MemStats) float64 { return float64(ms.NumGC) }))
+
+	return m
+}
+
+// metricMethod returns the request method to use as a metric label. The method comes
+// straight from the client, so anything outside the standard methods is counted as
+// "OTHER", otherwise each made-up method would create a new series.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// registerMovieCache adds the hit and miss counters and the size of the movie cache to
+// the registry.
+func (m *appMetrics) registerMovieCache(cache *data.MovieCache) {
+	m.registry.NewCounterFunc("movie_cache_hits_total", "Total number of movie reads answered from the cache.",
+		func() float64 { return float64(cache.Hits()) })
+	m.registry.NewCounterFunc("movie_cache_misses_total", "Total number of movie reads which missed the cache.",
+		func() float64 { return float64(cache.Misses()) })
+	m.registry.NewGaugeFunc("movie_cache_entries", "Number of entries in the movie cache.",
+		func() float64 { return float64(cache.Len()) })
+}
+
+// publishExpvars publishes the application's expvar variables: the version, the
+// number of goroutines, the database connection pool statistics and request and
+// response counters. expvar variables are global and can only be published once, so
+// this is called from main() rather than newAppMetrics().
+func (m *appMetrics) publishExpvars(db *sql.DB) {
+	expvar.NewString("version").Set(version)
+
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("database", expvar.Func(func() interface{} {
+		stats := db.Stats()
+		return map[string]interface{}{
+			"max_open_connections": stats.MaxOpenConnections,
+			"open_connections":     stats.OpenConnections,
+			"in_use":               stats.InUse,
+			"idle":                 stats.Idle,
+			"wait_count":           stats.WaitCount,
+			"wait_duration":        stats.WaitDuration.String(),
+			"max_idle_closed":      stats.MaxIdleClosed,
+			"max_idle_time_closed": stats.MaxIdleTimeClosed,
+		}
+	}))
+
+	expvar.Publish("timestamp", expvar.Func(func() interface{} {
+		return time.Now().Unix()
+	}))
+
+	m.totalRequestsReceived = expvar.NewInt("total_requests_received")
+	m.totalResponsesSent = expvar.NewInt("total_responses_sent")
+	m.totalProcessingTimeMicros = expvar.NewInt("total_processing_time_μs")
+	m.totalResponsesSentByStatus = expvar.NewMap("total_responses_sent_by_status")
+}
+
+// isLoopbackAddr reports whether a listen address (such as "localhost:4001" or
+// "127.0.0.1:4001") only accepts connections from the local machine. An address with
+// an empty host listens on every interface, so it isn't loopback.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
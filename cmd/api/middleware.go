@@ -1,19 +1,29 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"net"
+	mathrand "math/rand"
 	"net/http"
-	"sync"
+	"slices"
+	"strconv"
 	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/Ramdoni007/21Cinema/internal/trace"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
+				// http.ErrAbortHandler is used to abort a response which has already
+				// started (see exportMovieHandler), so pass it on to the server rather
+				// than trying to send an error response.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
 				w.Header().Set("Connection", "close")
 
 				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
@@ -25,77 +35,295 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
+// rateLimit applies the rate limit policy for the route to each request. It wraps each
+// route's handler (see routes()) rather than the whole router, because the policy
+// depends on the matched route; the router's 404 and 405 responses are wrapped too, and
+// fall under the default policy. Requests are counted per authenticated user when there
+// is one (see contextGetUser()), and per client IP otherwise. Each route override has
+// its own buckets, separate from those of the default policy.
+//
+// Every response which passes through here gets the RateLimit-* headers, including
+// when rate limiting is disabled or the store can't be reached. Nothing is counted
+// then, so the headers show the whole allowance as remaining.
+func (app *application) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The limiter settings can be changed by a configuration reload, so read them
+		// for each request.
+		settings := app.settings()
+
+		policy := settings.policyFor(r.Method, app.contextGetRoutePattern(r))
+		unlimited := rateLimitResult{allowed: true, limit: policy.burst, remaining: policy.burst}
+
+		if !settings.limiter.enabled {
+			setRateLimitHeaders(w, unlimited)
+			next(w, r)
+			return
+		}
+
+		// The client IP comes from the realIP middleware, so clients behind our load
+		// balancer each get their own bucket.
+		key := "ip:" + app.clientIP(r)
 
-	// Define a client struct to hold the rate limiter and last seen time for each
-	// client
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+		if user := app.contextGetUser(r); user != nil {
+			key = "user:" + strconv.FormatInt(user.ID, 10)
+		}
+
+		result, err := app.limiter.allow(r.Context(), policy.key(key), policy)
+		if err != nil {
+			// If the store can't be reached, let the request through rather than
+			// turning a problem with the rate limiter into an outage of the whole API.
+			app.logError(r, err)
+			setRateLimitHeaders(w, unlimited)
+			next(w, r)
+			return
+		}
+
+		setRateLimitHeaders(w, result)
+
+		if !result.allowed {
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		next(w, r)
 	}
-	// Declare a mutex and a map to hold the clients' IP addresses and rate limiters.
-	var (
-		mu sync.Mutex
-		// Update the map so the values are pointers to a client struct.
-		clients = make(map[string]*client)
-	)
-
-	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute.
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-
-			// Lock the mutex to prevent any rate limiter checks from happening while
-			// the cleanup is taking place.
-			mu.Lock()
-
-			// Loop through all clients. If they haven't been seen within the last three
-			// minutes, delete the corresponding entry from the map
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			// Importantly, unlock the mutex when the cleanup is complete.
-			mu.Unlock()
+}
+
+// responseRecorder wraps a http.ResponseWriter to capture the status code and the number
+// of bytes written, for use by metrics and logging middleware.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController
+// can reach its Flush() and SetWriteDeadline() methods (the export endpoint needs
+// both).
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// statusClientClosedRequest is the non-standard status code, borrowed from nginx, which
+// is recorded in the metrics and access log for requests abandoned by the client. It's
+// never sent, since there's no one to send it to.
+const statusClientClosedRequest = 499
+
+// recordStatus records status as the response status on every responseRecorder in the
+// chain of writers which w wraps, without sending anything to the client. Anything
+// written to the response afterwards doesn't change the recorded status.
+func recordStatus(w http.ResponseWriter, status int) {
+	for {
+		if rr, ok := w.(*responseRecorder); ok && !rr.wroteHeader {
+			rr.status = status
+			rr.wroteHeader = true
 		}
-	}()
 
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// recordMetrics counts each request and measures its latency, labelled by the route
+// pattern the router matched and the final status code.
+func (app *application) recordMetrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.metrics == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		// Only carry out the check if rate limiting is enabled.
-		if app.config.limiter.enabled {
+		start := time.Now()
 
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-				return
-			}
-			mu.Lock()
-
-			// Create and add a new client struct to the map if it doesn't already exist.
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					// Use the requests-per-second and burst values from the config
-					// struct.
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
+		r, _ = app.contextSetRouteInfo(r)
+		rec := newResponseRecorder(w)
 
-			// Update the last seen time for the client.
-			clients[ip].lastSeen = time.Now()
+		app.metrics.inFlight.Add(1)
+		defer app.metrics.inFlight.Add(-1)
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
+		if app.metrics.totalRequestsReceived != nil {
+			app.metrics.totalRequestsReceived.Add(1)
+		}
+
+		next.ServeHTTP(rec, r)
+
+		duration := time.Since(start)
+		status := strconv.Itoa(rec.status)
+		labels := []string{metricMethod(r.Method), app.contextGetRoutePattern(r), status}
+
+		app.metrics.requests.Inc(labels...)
+		app.metrics.duration.Observe(duration.Seconds(), labels...)
+
+		if app.metrics.totalResponsesSent != nil {
+			app.metrics.totalResponsesSent.Add(1)
+			app.metrics.totalProcessingTimeMicros.Add(duration.Microseconds())
+			app.metrics.totalResponsesSentByStatus.Add(status, 1)
+		}
+	})
+}
+
+// traceRequests starts a server span for every request, continuing the caller's trace
+// if the request carries a valid traceparent header. The span is stored in the request
+// context, so the data models can record their queries as children of it.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.tracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+
+		if parent, ok := trace.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = trace.ContextWithRemoteParent(ctx, parent)
+		}
+
+		ctx, span := app.tracer.Start(ctx, "HTTP "+r.Method, trace.KindServer)
+		defer span.End()
+
+		r, _ = app.contextSetRouteInfo(r.WithContext(ctx))
+		rec := newResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		// The route pattern is only known once the router has run, so the span is
+		// renamed at the end, e.g. "GET /v1/movies/:id".
+		route := app.contextGetRoutePattern(r)
+
+		span.SetName(r.Method + " " + route)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.status_code", strconv.Itoa(rec.status))
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", app.clientIP(r))
+
+		if rec.status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("%d %s", rec.status, http.StatusText(rec.status)))
+		}
+	})
+}
+
+// The maximum length of a client-supplied X-Request-ID header that we'll accept.
+const maxRequestIDLength = 128
+
+// requestID makes sure every request has an ID. A valid X-Request-ID header sent by
+// the client (or a proxy in front of us) is reused; otherwise a new random ID is
+// generated. The ID is echoed in the X-Request-ID response header, and a logger which
+// includes it (along with the client IP and trace id) is stored in the request context
+// for everything further down the chain to log with.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		properties := map[string]any{
+			"request_id": id,
+			"remote_ip":  app.clientIP(r),
+		}
 
-			mu.Unlock()
+		if sc := trace.SpanFromContext(r.Context()).SpanContext(); sc.IsValid() {
+			properties["trace_id"] = sc.TraceID.String()
+			properties["span_id"] = sc.SpanID.String()
 		}
 
+		r = app.contextSetRequestID(r, id)
+		r = app.contextSetLogger(r, app.logger.With(properties))
+
 		next.ServeHTTP(w, r)
 	})
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to reuse: not
+// empty, not too long, and made only of characters which can't be used to forge log
+// entries or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random 128-bit ID encoded as 32 hex characters.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
 
+// logRequests writes an access log entry for each request once it has been handled.
+// Requests to the configured skip paths aren't logged, and successful requests (status
+// below 400) are only logged at the configured sample rate; errors are always logged.
+// The entry is written through the request-scoped logger, so it carries the request ID
+// and client IP.
+func (app *application) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The access log settings can be changed by a configuration reload, so read
+		// them for each request.
+		settings := app.settings().accessLog
+
+		if !settings.enabled || slices.Contains(settings.skipPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		r, _ = app.contextSetRouteInfo(r)
+		rec := newResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status < http.StatusBadRequest && mathrand.Float64() >= settings.sampleRate {
+			return
+		}
+
+		duration := time.Since(start)
+
+		app.contextGetLogger(r).PrintInfo("request completed", map[string]any{
+			"method":     r.Method,
+			"route":      app.contextGetRoutePattern(r),
+			"path":       r.URL.Path,
+			"status":     rec.status,
+			"latency_ms": float64(duration.Microseconds()) / 1000,
+			"bytes":      rec.bytes,
+			"user_agent": r.UserAgent(),
+		})
+	})
 }
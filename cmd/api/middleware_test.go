@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+)
+
+func TestRateLimitHeadersOnEveryResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		method     string
+		url        string
+		wantStatus int
+	}{
+		{"not found", true, http.MethodGet, "/v1/nothing-here", http.StatusNotFound},
+		{"method not allowed", true, http.MethodPut, "/v1/movies", http.StatusMethodNotAllowed},
+		{"limiter disabled", false, http.MethodGet, "/v1/nothing-here", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, func(cfg *config) {
+				cfg.limiter.enabled = tt.enabled
+			})
+
+			r := httptest.NewRequest(tt.method, tt.url, nil)
+
+			res := serve(app.routes(), r)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+
+			// The default policy applies to all of these, and each is the client's first
+			// request, so the whole burst is left (less this request, when counted).
+			wantRemaining := "4"
+			if tt.enabled {
+				wantRemaining = "3"
+			}
+
+			if got := res.Header.Get("RateLimit-Limit"); got != "4" {
+				t.Errorf("got RateLimit-Limit %q; want %q", got, "4")
+			}
+			if got := res.Header.Get("RateLimit-Remaining"); got != wantRemaining {
+				t.Errorf("got RateLimit-Remaining %q; want %q", got, wantRemaining)
+			}
+			if res.Header.Get("RateLimit-Reset") == "" {
+				t.Error("RateLimit-Reset header missing")
+			}
+		})
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	app := newTestApplication(t, func(cfg *config) {
+		cfg.limiter.burst = 1
+		cfg.limiter.rps = 0.001
+	})
+
+	handler := app.rateLimit(func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(remoteAddr, clientIP string, user *data.User) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if clientIP != "" {
+			r = app.contextSetClientIP(r, clientIP)
+		}
+		if user != nil {
+			r = app.contextSetUser(r, user)
+		}
+		return serve(handler, r).StatusCode
+	}
+
+	alice := &data.User{ID: 1}
+	bob := &data.User{ID: 2}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		clientIP   string // as set by the realIP middleware
+		user       *data.User
+		wantStatus int
+	}{
+		{"first anonymous request", "192.0.2.1:1234", "", nil, http.StatusOK},
+		{"same IP, other port", "192.0.2.1:5678", "", nil, http.StatusTooManyRequests},
+		{"other IP", "192.0.2.2:1234", "", nil, http.StatusOK},
+		{"client behind a proxy", "10.0.0.1:1234", "198.51.100.7", nil, http.StatusOK},
+		{"same client, through the proxy again", "10.0.0.1:5678", "198.51.100.7", nil, http.StatusTooManyRequests},
+		{"other client, same proxy", "10.0.0.1:1234", "198.51.100.8", nil, http.StatusOK},
+		{"user on a limited IP", "192.0.2.1:1234", "", alice, http.StatusOK},
+		{"same user, other IP", "192.0.2.3:1234", "", alice, http.StatusTooManyRequests},
+		{"other user, same IP", "192.0.2.1:1234", "", bob, http.StatusOK},
+	}
+
+	// The cases run in order, each building on the buckets left by the ones before.
+	for _, tt := range tests {
+		if status := request(tt.remoteAddr, tt.clientIP, tt.user); status != tt.wantStatus {
+			t.Errorf("%s: got status %d; want %d", tt.name, status, tt.wantStatus)
+		}
+	}
+}
+
+func TestActor(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	tests := []struct {
+		name     string
+		clientIP string
+		user     *data.User
+		want     string
+	}{
+		{"remote address", "", nil, "192.0.2.1"},
+		{"client IP from the proxy headers", "198.51.100.7", nil, "198.51.100.7"},
+		{"authenticated user", "198.51.100.7", &data.User{ID: 42}, "user:42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			if tt.clientIP != "" {
+				r = app.contextSetClientIP(r, tt.clientIP)
+			}
+			if tt.user != nil {
+				r = app.contextSetUser(r, tt.user)
+			}
+
+			if got := app.actor(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanceledRequestStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		cancel     bool
+		wantStatus int
+	}{
+		{"client went away", true, statusClientClosedRequest},
+		{"database too slow", false, http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil).WithContext(ctx)
+			r.Header.Set("Accept-Encoding", "gzip")
+
+			// The recorders are outside compress() in the real chain, so the status has
+			// to be recorded through the compressWriter.
+			var rec *responseRecorder
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rec = newResponseRecorder(w)
+				app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					app.serverErrorResponse(w, r, context.DeadlineExceeded)
+				})).ServeHTTP(rec, r)
+			})
+
+			serve(handler, r)
+
+			if rec.status != tt.wantStatus {
+				t.Errorf("got recorded status %d; want %d", rec.status, tt.wantStatus)
+			}
+		})
+	}
+}
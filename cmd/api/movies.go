@@ -48,7 +48,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Call the Insert() method on our movies model, passing in a pointer to the
 	// validated movie struct. This will create a record in the database and update the
 	// movie struct with the system-generated information.
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.WithActor(app.actor(r)).Insert(r.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -87,7 +87,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -100,6 +100,12 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 
 	}
 
+	// The version makes an ETag for the movie. If the client already has this version,
+	// there's no need to send it again.
+	if notModified(w, r, movieETag(movie)) {
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -116,10 +122,15 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Fetch the existing movie record from the database, sending a 404 Not Found
-	// response to the client if we couldn't find a matching record.
-	movie, err := app.models.Movies.Get(id)
+	// response to the client if we couldn't find a matching record. If-Match never
+	// matches a movie which doesn't exist, so with that header it's a 412 instead.
+	ifMatch := conditionHeader(r, "If-Match")
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrRecordNotFound) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -130,6 +141,14 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// If the client sent an If-Match header, only go ahead if it matches the version
+	// we just fetched. The update itself is made conditional on that same version
+	// below, so a change made in between is caught too.
+	if ifMatch != "" && !etagMatches(ifMatch, movieETag(movie), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	// Declare an input struct to hold the expected data from the client.
 	// Use pointers for the Title, Year and Runtime fields. for Partial specific update API response
 	var input struct {
@@ -182,9 +201,13 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Pass the updated movie record to our new Update() method.And
 	// Intercept any ErrEditConflict error and call the new editConflictResponse()
 	// helper. be safe for race condition:)
-	err = app.models.Movies.Update(movie)
+	// If the client made the request conditional with If-Match, an edit conflict means
+	// the precondition no longer holds, so report it as such.
+	err = app.models.Movies.WithActor(app.actor(r)).Update(r.Context(), movie)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -194,7 +217,10 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Write the updated movie record in a JSON response.
+	// Write the updated movie record in a JSON response, with the ETag of its new
+	// version.
+	w.Header().Set("ETag", movieETag(movie))
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -206,13 +232,41 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 	id, err := app.readIDParams(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
 	// Delete the movie from the database, sending a 404 Not Found response to the
-	// client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	// client if there isn't a matching record. If the client sent an If-Match header,
+	// lock the movie and check it against the current version first, so that it can't
+	// change between the check and the delete.
+	movies := app.models.Movies.WithActor(app.actor(r))
+
+	if ifMatch := conditionHeader(r, "If-Match"); ifMatch != "" {
+		err = movies.Batch(r.Context(), func(tx data.MovieModel) error {
+			// If-Match never matches a movie which doesn't exist (RFC 9110, section
+			// 13.1.1), so that's a failed precondition rather than a 404.
+			movie, err := tx.GetForUpdate(r.Context(), id)
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return errPreconditionFailed
+			}
+			if err != nil {
+				return err
+			}
+
+			if !etagMatches(ifMatch, movieETag(movie), false) {
+				return errPreconditionFailed
+			}
+
+			return tx.Delete(r.Context(), id)
+		})
+	} else {
+		err = movies.Delete(r.Context(), id)
+	}
+
 	if err != nil {
 		switch {
+		case errors.Is(err, errPreconditionFailed):
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -258,13 +312,19 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 
 	}
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 
 	}
 
+	// Lists get a weak ETag (see movieListETag()), so clients polling a page can be
+	// told when nothing on it has changed.
+	if notModified(w, r, movieListETag(movies, metadata)) {
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 
 	if err != nil {
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitPolicy is a token bucket: requests are allowed at rps per second on average,
+// with bursts of up to burst requests.
+type rateLimitPolicy struct {
+	name  string
+	rps   float64
+	burst int
+}
+
+// key returns the key under which the requests of client (such as "ip:192.0.2.1") are
+// counted against the policy. The policy name is part of it, so each route override
+// has buckets of its own, separate from those of the default policy.
+func (p rateLimitPolicy) key(client string) string {
+	return p.name + "|" + client
+}
+
+// routeLimits holds the per-route overrides of the default rate limit policy, keyed by
+// "METHOD /pattern" (the method may be "*" to match any). It's a flag.Value, set from a
+// space-separated list of METHOD:PATTERN=RPS,BURST entries, for example:
+//
+//	-limiter-routes="POST:/v1/users=0.1,3 *:/v1/movies/import=0.5,2"
+type routeLimits map[string]rateLimitPolicy
+
+func (rl *routeLimits) String() string {
+	entries := make([]string, 0, len(*rl))
+	for _, policy := range *rl {
+		method, pattern, _ := strings.Cut(policy.name, " ")
+		entries = append(entries, fmt.Sprintf("%s:%s=%s,%d",
+			method, pattern, strconv.FormatFloat(policy.rps, 'f', -1, 64), policy.burst))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, " ")
+}
+
+func (rl *routeLimits) Set(val string) error {
+	limits := make(routeLimits)
+
+	for _, entry := range strings.Fields(val) {
+		method, rest, ok := strings.Cut(entry, ":")
+		if !ok {
+			return fmt.Errorf("%q: must be METHOD:PATTERN=RPS,BURST", entry)
+		}
+
+		// Split on the last "=", since only the limits come after it.
+		i := strings.LastIndex(rest, "=")
+		if i < 0 {
+			return fmt.Errorf("%q: must be METHOD:PATTERN=RPS,BURST", entry)
+		}
+		pattern, values := rest[:i], rest[i+1:]
+
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("%q: the route pattern must start with /", entry)
+		}
+
+		rpsValue, burstValue, ok := strings.Cut(values, ",")
+		if !ok {
+			return fmt.Errorf("%q: must be METHOD:PATTERN=RPS,BURST", entry)
+		}
+
+		rps, err := strconv.ParseFloat(rpsValue, 64)
+		if err != nil || rps <= 0 {
+			return fmt.Errorf("%q: the rps must be a number greater than zero", entry)
+		}
+
+		burst, err := strconv.Atoi(burstValue)
+		if err != nil || burst < 1 {
+			return fmt.Errorf("%q: the burst must be a whole number of at least 1", entry)
+		}
+
+		name := strings.ToUpper(method) + " " + pattern
+		limits[name] = rateLimitPolicy{name: name, rps: rps, burst: burst}
+	}
+
+	*rl = limits
+	return nil
+}
+
+// policyFor returns the rate limit policy for a route: an override for the method and
+// pattern if there is one, then an override for any method, then the default.
+func (cfg config) policyFor(method, pattern string) rateLimitPolicy {
+	if policy, ok := cfg.limiter.routes[method+" "+pattern]; ok {
+		return policy
+	}
+	if policy, ok := cfg.limiter.routes["* "+pattern]; ok {
+		return policy
+	}
+	return rateLimitPolicy{name: "default", rps: cfg.limiter.rps, burst: cfg.limiter.burst}
+}
+
+// rateLimitResult describes the state of a client's bucket after a request, for the
+// RateLimit-* response headers.
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration // Until the bucket is full again.
+	retryAfter time.Duration // Until the next request would be allowed, if this one wasn't.
+}
+
+// rateLimiter is implemented by the stores which can keep track of rate limits. The
+// in-memory store is fast and needs no setup, but each instance of the API counts
+// requests separately, so behind a load balancer a client gets the limit once per
+// instance. The Postgres store shares the counts between all the instances using the
+// database, at the cost of a query per request.
+type rateLimiter interface {
+	// allow counts a request for key against the policy, and reports whether it should
+	// be served.
+	allow(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error)
+
+	// stop ends the store's background cleanup. It's safe to call more than once.
+	stop()
+}
+
+// newRateLimiter returns the rate limit store selected by the limiter-store setting.
+func newRateLimiter(cfg config, models data.Models, logger *jsonlog.Logger) rateLimiter {
+	if cfg.limiter.store == "postgres" {
+		return newPostgresRateLimiter(models.RateLimits, logger)
+	}
+	return newMemoryRateLimiter()
+}
+
+// memoryRateLimiter holds a token bucket for each client and policy.
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*rateLimitClient
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Define a client struct to hold the rate limiter and last seen time for each client.
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newMemoryRateLimiter returns an empty memoryRateLimiter, and launches a background
+// goroutine which removes old entries from its clients map once every minute, until
+// stop() is called.
+func newMemoryRateLimiter() *memoryRateLimiter {
+	rl := &memoryRateLimiter{
+		clients: make(map[string]*rateLimitClient),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-rl.done:
+				return
+			}
+
+			// Lock the mutex to prevent any rate limiter checks from happening while
+			// the cleanup is taking place.
+			rl.mu.Lock()
+
+			// Loop through all clients. If they haven't been seen within the last three
+			// minutes, delete the corresponding entry from the map.
+			for key, client := range rl.clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(rl.clients, key)
+				}
+			}
+
+			// Importantly, unlock the mutex when the cleanup is complete.
+			rl.mu.Unlock()
+		}
+	}()
+
+	return rl
+}
+
+// stop ends the cleanup goroutine.
+func (rl *memoryRateLimiter) stop() {
+	rl.stopOnce.Do(func() { close(rl.done) })
+}
+
+// allow takes a token from the bucket for key, creating the bucket if needed. It never
+// returns an error.
+func (rl *memoryRateLimiter) allow(_ context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	limit := rate.Limit(policy.rps)
+
+	client, found := rl.clients[key]
+	if !found {
+		client = &rateLimitClient{limiter: rate.NewLimiter(limit, policy.burst)}
+		rl.clients[key] = client
+	}
+
+	// If the settings have been reloaded since the client's limiter was created, bring
+	// it up to date.
+	if client.limiter.Limit() != limit {
+		client.limiter.SetLimitAt(now, limit)
+	}
+	if client.limiter.Burst() != policy.burst {
+		client.limiter.SetBurstAt(now, policy.burst)
+	}
+
+	// Update the last seen time for the client.
+	client.lastSeen = now
+
+	result := rateLimitResult{
+		allowed: client.limiter.AllowN(now, 1),
+		limit:   policy.burst,
+	}
+
+	tokens := client.limiter.TokensAt(now)
+
+	result.remaining = int(math.Max(0, math.Floor(tokens)))
+	result.reset = tokenTime(float64(policy.burst)-tokens, policy.rps)
+
+	if !result.allowed {
+		result.retryAfter = tokenTime(1-tokens, policy.rps)
+	}
+
+	return result, nil
+}
+
+// tokenTime returns how long it takes to accumulate n tokens at rps per second.
+func tokenTime(n, rps float64) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(n / rps * float64(time.Second))
+}
+
+// setRateLimitHeaders sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers (from the IETF RateLimit header fields draft), plus Retry-After if the request
+// was refused. Times are in whole seconds, rounded up.
+func setRateLimitHeaders(w http.ResponseWriter, result rateLimitResult) {
+	seconds := func(d time.Duration) string {
+		return strconv.Itoa(int(math.Ceil(d.Seconds())))
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	w.Header().Set("RateLimit-Reset", seconds(result.reset))
+
+	if !result.allowed {
+		w.Header().Set("Retry-After", seconds(result.retryAfter))
+	}
+}
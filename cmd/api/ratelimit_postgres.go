@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+)
+
+// postgresRateLimiter keeps its counts in the rate_limit_windows table, so that every
+// instance of the API sharing the database enforces the same limits.
+//
+// Rather than a token bucket, which would need a read-modify-write of the bucket for
+// every request, it uses a sliding window counter. Requests are counted in fixed
+// windows, and the number in the sliding window ending now is estimated by adding the
+// count for the current window to a share of the previous window's count, in proportion
+// to how much of the sliding window overlaps it. That's a single upsert per request.
+//
+// A policy of rps requests per second with bursts of burst is mapped onto a window of
+// burst/rps seconds holding up to burst requests. That allows the same average rate and
+// the same burst size as the token bucket, although the bucket refills smoothly where
+// the window forgets requests in steps.
+type postgresRateLimiter struct {
+	model rateLimitStore
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// rateLimitStore is the part of data.RateLimitModel which postgresRateLimiter uses. It's
+// an interface so that the tests can stand in for the database.
+type rateLimitStore interface {
+	Hit(ctx context.Context, key string, window time.Duration) (data.RateLimitWindow, error)
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// newPostgresRateLimiter returns a postgresRateLimiter using model, and launches a
+// background goroutine which deletes expired windows once every minute, until stop()
+// is called.
+func newPostgresRateLimiter(model rateLimitStore, logger *jsonlog.Logger) *postgresRateLimiter {
+	rl := &postgresRateLimiter{model: model, done: make(chan struct{})}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-rl.done:
+				return
+			}
+
+			if _, err := model.DeleteExpired(context.Background()); err != nil {
+				logger.PrintError(err, map[string]any{"action": "delete expired rate limit windows"})
+			}
+		}
+	}()
+
+	return rl
+}
+
+// stop ends the cleanup goroutine, if one was started.
+func (rl *postgresRateLimiter) stop() {
+	rl.stopOnce.Do(func() {
+		if rl.done != nil {
+			close(rl.done)
+		}
+	})
+}
+
+// allow counts a request for key in the current window, and works out whether the
+// sliding window is over the limit. Refused requests are counted too, so a client which
+// keeps retrying without waiting stays refused.
+func (rl *postgresRateLimiter) allow(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error) {
+	window := time.Duration(float64(policy.burst) / policy.rps * float64(time.Second))
+
+	hit, err := rl.model.Hit(ctx, key, window)
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+
+	limit := float64(policy.burst)
+	previous, current := float64(hit.Previous), float64(hit.Current)
+	count := previous*(1-hit.Elapsed) + current
+
+	result := rateLimitResult{
+		allowed:   count <= limit,
+		limit:     policy.burst,
+		remaining: int(math.Max(0, math.Floor(limit-count))),
+		// This request is counted until the end of the next window.
+		reset: windowTime(2-hit.Elapsed, window),
+	}
+
+	if !result.allowed {
+		result.retryAfter = slidingWindowRetry(previous, current, limit, hit.Elapsed, window)
+	}
+
+	return result, nil
+}
+
+// slidingWindowRetry returns how long it is until one more request would fit in the
+// sliding window. While we're in the current window, the previous window's share of the
+// count falls as we move through it; after that, the current window's share does.
+func slidingWindowRetry(previous, current, limit, elapsed float64, window time.Duration) time.Duration {
+	if current+1 <= limit && previous > 0 {
+		// Solve previous*(1-elapsed-t) + current + 1 <= limit for t, as a fraction of the
+		// window.
+		return windowTime(1-elapsed-(limit-current-1)/previous, window)
+	}
+
+	// Wait for the next window, then solve current*(1-t) + 1 <= limit for t.
+	return windowTime(1-elapsed+1-(limit-1)/current, window)
+}
+
+// windowTime converts a fraction of a window into a duration.
+func windowTime(fraction float64, window time.Duration) time.Duration {
+	if fraction <= 0 {
+		return 0
+	}
+	return time.Duration(fraction * float64(window))
+}
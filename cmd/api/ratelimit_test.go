@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+)
+
+func TestMemoryRateLimiterBurst(t *testing.T) {
+	rl := newMemoryRateLimiter()
+	t.Cleanup(rl.stop)
+	policy := rateLimitPolicy{name: "default", rps: 0.001, burst: 3}
+
+	tests := []struct {
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{true, 2},
+		{true, 1},
+		{true, 0},
+		{false, 0},
+		{false, 0},
+	}
+
+	for i, tt := range tests {
+		result, err := rl.allow(context.Background(), policy.key("ip:192.0.2.1"), policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.allowed != tt.wantAllowed {
+			t.Errorf("request %d: got allowed %t; want %t", i+1, result.allowed, tt.wantAllowed)
+		}
+		if result.remaining != tt.wantRemaining {
+			t.Errorf("request %d: got remaining %d; want %d", i+1, result.remaining, tt.wantRemaining)
+		}
+		if result.limit != policy.burst {
+			t.Errorf("request %d: got limit %d; want %d", i+1, result.limit, policy.burst)
+		}
+		if !result.allowed && result.retryAfter <= 0 {
+			t.Errorf("request %d: got retryAfter %v; want more than 0", i+1, result.retryAfter)
+		}
+	}
+
+	// Other keys and other policies have buckets of their own.
+	for _, tt := range []struct {
+		key    string
+		policy rateLimitPolicy
+	}{
+		{"ip:192.0.2.2", policy},
+		{"ip:192.0.2.1", rateLimitPolicy{name: "POST /v1/users", rps: 0.001, burst: 3}},
+	} {
+		result, _ := rl.allow(context.Background(), tt.policy.key(tt.key), tt.policy)
+		if !result.allowed {
+			t.Errorf("%s for %s: refused; want allowed", tt.policy.name, tt.key)
+		}
+	}
+}
+
+func TestRateLimiterStop(t *testing.T) {
+	limiters := map[string]rateLimiter{
+		"memory":   newMemoryRateLimiter(),
+		"postgres": newPostgresRateLimiter(&stubRateLimitStore{}, jsonlog.New(io.Discard, jsonlog.LevelOff)),
+	}
+
+	for name, rl := range limiters {
+		// Stopping twice, as a test cleanup and a shutdown might, must not panic.
+		rl.stop()
+		rl.stop()
+
+		var done chan struct{}
+		switch rl := rl.(type) {
+		case *memoryRateLimiter:
+			done = rl.done
+		case *postgresRateLimiter:
+			done = rl.done
+		}
+
+		select {
+		case <-done:
+		default:
+			t.Errorf("%s: cleanup not signalled to stop", name)
+		}
+	}
+}
+
+func TestMemoryRateLimiterRefill(t *testing.T) {
+	rl := newMemoryRateLimiter()
+	t.Cleanup(rl.stop)
+
+	// One token every 20ms.
+	policy := rateLimitPolicy{name: "default", rps: 50, burst: 1}
+
+	result, _ := rl.allow(context.Background(), policy.key("ip:192.0.2.1"), policy)
+	if !result.allowed {
+		t.Fatal("first request refused")
+	}
+
+	result, _ = rl.allow(context.Background(), policy.key("ip:192.0.2.1"), policy)
+	if result.allowed {
+		t.Fatal("second request allowed with an empty bucket")
+	}
+	if result.retryAfter <= 0 || result.retryAfter > 20*time.Millisecond {
+		t.Errorf("got retryAfter %v; want up to 20ms", result.retryAfter)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	result, _ = rl.allow(context.Background(), policy.key("ip:192.0.2.1"), policy)
+	if !result.allowed {
+		t.Fatal("request after the bucket refilled was refused")
+	}
+}
+
+func TestMemoryRateLimiterReload(t *testing.T) {
+	tests := []struct {
+		name          string
+		before        rateLimitPolicy
+		after         rateLimitPolicy
+		used          int // Requests made under the policy before the reload.
+		wantAllowed   bool
+		wantLimit     int
+		wantRemaining int
+		maxRetryAfter time.Duration // If set, the most retryAfter may be.
+	}{
+		{
+			name:          "burst lowered",
+			before:        rateLimitPolicy{name: "default", rps: 0.001, burst: 5},
+			after:         rateLimitPolicy{name: "default", rps: 0.001, burst: 2},
+			used:          1,
+			wantAllowed:   true,
+			wantLimit:     2,
+			wantRemaining: 1,
+		},
+		{
+			name:          "burst raised",
+			before:        rateLimitPolicy{name: "default", rps: 0.001, burst: 1},
+			after:         rateLimitPolicy{name: "default", rps: 0.001, burst: 3},
+			used:          1,
+			wantAllowed:   false,
+			wantLimit:     3,
+			wantRemaining: 0,
+		},
+		{
+			// The new rate applies from the reload onwards, so the bucket is still
+			// empty, but it refills at the new rate.
+			name:          "rate raised",
+			before:        rateLimitPolicy{name: "default", rps: 0.001, burst: 1},
+			after:         rateLimitPolicy{name: "default", rps: 1000, burst: 1},
+			used:          1,
+			wantAllowed:   false,
+			wantLimit:     1,
+			wantRemaining: 0,
+			maxRetryAfter: time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := newMemoryRateLimiter()
+			t.Cleanup(rl.stop)
+
+			for i := 0; i < tt.used; i++ {
+				rl.allow(context.Background(), tt.before.key("ip:192.0.2.1"), tt.before)
+			}
+
+			result, _ := rl.allow(context.Background(), tt.after.key("ip:192.0.2.1"), tt.after)
+
+			if result.allowed != tt.wantAllowed {
+				t.Errorf("got allowed %t; want %t", result.allowed, tt.wantAllowed)
+			}
+			if result.limit != tt.wantLimit {
+				t.Errorf("got limit %d; want %d", result.limit, tt.wantLimit)
+			}
+			if result.remaining != tt.wantRemaining {
+				t.Errorf("got remaining %d; want %d", result.remaining, tt.wantRemaining)
+			}
+			if tt.maxRetryAfter > 0 && result.retryAfter > tt.maxRetryAfter {
+				t.Errorf("got retryAfter %v; want at most %v", result.retryAfter, tt.maxRetryAfter)
+			}
+		})
+	}
+}
+
+func TestSetRateLimitHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		result         rateLimitResult
+		wantLimit      string
+		wantRemaining  string
+		wantReset      string
+		wantRetryAfter string
+	}{
+		{
+			name:          "allowed",
+			result:        rateLimitResult{allowed: true, limit: 4, remaining: 3, reset: 500 * time.Millisecond},
+			wantLimit:     "4",
+			wantRemaining: "3",
+			wantReset:     "1",
+		},
+		{
+			name:          "full",
+			result:        rateLimitResult{allowed: true, limit: 4, remaining: 4},
+			wantLimit:     "4",
+			wantRemaining: "4",
+			wantReset:     "0",
+		},
+		{
+			name:           "refused",
+			result:         rateLimitResult{allowed: false, limit: 4, remaining: 0, reset: 2 * time.Second, retryAfter: 1100 * time.Millisecond},
+			wantLimit:      "4",
+			wantRemaining:  "0",
+			wantReset:      "2",
+			wantRetryAfter: "2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			setRateLimitHeaders(rr, tt.result)
+
+			for header, want := range map[string]string{
+				"RateLimit-Limit":     tt.wantLimit,
+				"RateLimit-Remaining": tt.wantRemaining,
+				"RateLimit-Reset":     tt.wantReset,
+				"Retry-After":         tt.wantRetryAfter,
+			} {
+				if got := rr.Header().Get(header); got != want {
+					t.Errorf("got %s %q; want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+// stubRateLimitStore stands in for data.RateLimitModel, returning a fixed window.
+type stubRateLimitStore struct {
+	hit    data.RateLimitWindow
+	err    error
+	window time.Duration // The window passed to the last call to Hit().
+}
+
+func (s *stubRateLimitStore) Hit(_ context.Context, _ string, window time.Duration) (data.RateLimitWindow, error) {
+	s.window = window
+	return s.hit, s.err
+}
+
+func (s *stubRateLimitStore) DeleteExpired(context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestPostgresRateLimiterAllow(t *testing.T) {
+	// A window of 10 seconds holding up to 10 requests.
+	policy := rateLimitPolicy{name: "default", rps: 1, burst: 10}
+
+	tests := []struct {
+		name           string
+		hit            data.RateLimitWindow
+		wantAllowed    bool
+		wantRemaining  int
+		wantReset      time.Duration
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:          "first request",
+			hit:           data.RateLimitWindow{Previous: 0, Current: 1, Elapsed: 0.5},
+			wantAllowed:   true,
+			wantRemaining: 9,
+			wantReset:     15 * time.Second,
+		},
+		{
+			name:          "exactly at the limit",
+			hit:           data.RateLimitWindow{Previous: 10, Current: 5, Elapsed: 0.5},
+			wantAllowed:   true,
+			wantRemaining: 0,
+			wantReset:     15 * time.Second,
+		},
+		{
+			name:          "previous window fades first",
+			hit:           data.RateLimitWindow{Previous: 10, Current: 6, Elapsed: 0.5},
+			wantAllowed:   false,
+			wantRemaining: 0,
+			wantReset:     15 * time.Second,
+			// 10*(1-0.5-t) + 6 + 1 <= 10 for t >= 0.2 windows.
+			wantRetryAfter: 2 * time.Second,
+		},
+		{
+			name:          "current window full",
+			hit:           data.RateLimitWindow{Previous: 4, Current: 10, Elapsed: 0.25},
+			wantAllowed:   false,
+			wantRemaining: 0,
+			wantReset:     17500 * time.Millisecond,
+			// The rest of this window, then 10*(1-t) + 1 <= 10 for t >= 0.1 windows.
+			wantRetryAfter: 8500 * time.Millisecond,
+		},
+		{
+			name:          "no previous window",
+			hit:           data.RateLimitWindow{Previous: 0, Current: 11, Elapsed: 0.5},
+			wantAllowed:   false,
+			wantRemaining: 0,
+			wantReset:     15 * time.Second,
+			// The rest of this window, then 11*(1-t) + 1 <= 10 for t >= 2/11 windows.
+			wantRetryAfter: 5*time.Second + 10*time.Second*2/11,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &stubRateLimitStore{hit: tt.hit}
+			rl := &postgresRateLimiter{model: store}
+
+			result, err := rl.allow(context.Background(), "default|ip:192.0.2.1", policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if store.window != 10*time.Second {
+				t.Errorf("got window %v; want %v", store.window, 10*time.Second)
+			}
+			if result.allowed != tt.wantAllowed {
+				t.Errorf("got allowed %t; want %t", result.allowed, tt.wantAllowed)
+			}
+			if result.limit != policy.burst {
+				t.Errorf("got limit %d; want %d", result.limit, policy.burst)
+			}
+			if result.remaining != tt.wantRemaining {
+				t.Errorf("got remaining %d; want %d", result.remaining, tt.wantRemaining)
+			}
+			if !durationNear(result.reset, tt.wantReset) {
+				t.Errorf("got reset %v; want %v", result.reset, tt.wantReset)
+			}
+			if !durationNear(result.retryAfter, tt.wantRetryAfter) {
+				t.Errorf("got retryAfter %v; want %v", result.retryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestPostgresRateLimiterError(t *testing.T) {
+	storeErr := errors.New("connection refused")
+	rl := &postgresRateLimiter{model: &stubRateLimitStore{err: storeErr}}
+
+	_, err := rl.allow(context.Background(), "default|ip:192.0.2.1", rateLimitPolicy{name: "default", rps: 1, burst: 1})
+	if !errors.Is(err, storeErr) {
+		t.Fatalf("got error %v; want %v", err, storeErr)
+	}
+}
+
+func TestSlidingWindowRetry(t *testing.T) {
+	window := 10 * time.Second
+
+	tests := []struct {
+		name                              string
+		previous, current, limit, elapsed float64
+		want                              time.Duration
+	}{
+		{
+			name:     "current at the limit",
+			previous: 5, current: 10, limit: 10, elapsed: 0,
+			// The whole of this window, then 10*(1-t) + 1 <= 10 for t >= 0.1.
+			want: 11 * time.Second,
+		},
+		{
+			name:     "current at the limit without a previous window",
+			previous: 0, current: 10, limit: 10, elapsed: 0.5,
+			want: 6 * time.Second,
+		},
+		{
+			name:     "limit of one",
+			previous: 0, current: 1, limit: 1, elapsed: 0.3,
+			// The next request only fits once this one has left the window entirely.
+			want: 17 * time.Second,
+		},
+		{
+			name:     "previous window only",
+			previous: 10, current: 0, limit: 5, elapsed: 0.2,
+			// 10*(1-0.2-t) + 1 <= 5 for t >= 0.4.
+			want: 4 * time.Second,
+		},
+		{
+			name:     "never negative",
+			previous: 100, current: 1, limit: 10, elapsed: 0.99,
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := slidingWindowRetry(tt.previous, tt.current, tt.limit, tt.elapsed, window)
+			if !durationNear(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// durationNear reports whether two durations are within a microsecond of each other,
+// to allow for floating point rounding.
+func durationNear(a, b time.Duration) bool {
+	d := a - b
+	return d > -time.Microsecond && d < time.Microsecond
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// prefixList is a flag.Value holding a space-separated list of CIDR prefixes, such as
+// the trusted proxy networks. A bare IP address is accepted as a single-address prefix.
+type prefixList []netip.Prefix
+
+func (l *prefixList) String() string {
+	values := make([]string, len(*l))
+	for i, prefix := range *l {
+		values[i] = prefix.String()
+	}
+	return strings.Join(values, " ")
+}
+
+func (l *prefixList) Set(val string) error {
+	var prefixes prefixList
+
+	for _, field := range strings.Fields(val) {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	*l = prefixes
+	return nil
+}
+
+// contains reports whether addr is in any of the prefixes.
+func (l prefixList) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range l {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// realIP works out the IP address of the client and stores it in the request context,
+// where app.clientIP() picks it up for rate limiting, logging and revision history.
+//
+// If the request came directly from a client, that's the remote address of the
+// connection. If it came through one of our trusted proxies (such as the load
+// balancer), the proxies will have recorded the client's address in the Forwarded,
+// X-Forwarded-For or X-Real-IP header, in that order of preference. Those headers can
+// be set by anyone, so they're only believed when the connection comes from a trusted
+// proxy, and the forwarding chain is only followed back through hops which are
+// themselves trusted proxies.
+func (app *application) realIP(next http.Handler) http.Handler {
+	trusted := app.config.trustedProxies
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, app.contextSetClientIP(r, resolveClientIP(r, trusted)))
+	})
+}
+
+// resolveClientIP returns the client IP for the request, as described for realIP().
+func resolveClientIP(r *http.Request, trusted prefixList) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !trusted.contains(remote) {
+		return host
+	}
+
+	// The forwarding headers list the hops in the order they were added, so the
+	// nearest hop is last. Walk back from it, skipping our own proxies; the first
+	// address which isn't one of them is the client.
+	chain := forwardedFor(r.Header)
+	if len(chain) == 0 {
+		chain = xForwardedFor(r.Header)
+	}
+
+	if len(chain) > 0 {
+		client := remote
+
+		for i := len(chain) - 1; i >= 0; i-- {
+			addr, ok := parseHop(chain[i])
+			if !ok {
+				// An obfuscated or malformed hop ("unknown", say) ends the chain we
+				// can follow, so the nearest address we can vouch for is the best we
+				// can do.
+				break
+			}
+
+			client = addr
+			if !trusted.contains(addr) {
+				break
+			}
+		}
+
+		return client.Unmap().String()
+	}
+
+	if addr, ok := parseHop(r.Header.Get("X-Real-IP")); ok {
+		return addr.Unmap().String()
+	}
+
+	return host
+}
+
+// forwardedFor returns the "for" values from the Forwarded headers (RFC 7239), in
+// order, for example ["192.0.2.60", "\"[2001:db8:cafe::17]:4711\""].
+func forwardedFor(header http.Header) []string {
+	var chain []string
+
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					chain = append(chain, val)
+				}
+			}
+		}
+	}
+
+	return chain
+}
+
+// xForwardedFor returns the addresses from the X-Forwarded-For headers, in order.
+func xForwardedFor(header http.Header) []string {
+	var chain []string
+
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+
+	return chain
+}
+
+// parseHop parses a single hop from a forwarding header. Forwarded values may be quoted
+// and IPv6 addresses bracketed, and either form may include a port.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(hop, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr, true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"sort"
+
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+)
+
+// reloadableSettings are the settings which can be changed on a running server by
+// sending it SIGHUP. They're all read per request (or applied directly to the logger),
+// so a new value takes effect straight away. Changes to any other setting are reported
+// but only take effect after a restart.
+var reloadableSettings = map[string]bool{
+	"limiter-rps":          true,
+	"limiter-burst":        true,
+	"limiter-enabled":      true,
+	"limiter-routes":       true,
+	"log-level":            true,
+	"log-traces":           true,
+	"access-log":           true,
+	"access-log-sample":    true,
+	"access-log-skip":      true,
+	"compress":             true,
+	"compress-min-size":    true,
+	"json-indent":          true,
+	"cors-trusted-origins": true,
+	"handler-timeout":      true,
+	"route-timeouts":       true,
+}
+
+// settings returns the current configuration. Code which reads a reloadable setting
+// while serving requests must use this rather than app.config, which always holds the
+// configuration the server started with.
+func (app *application) settings() *config {
+	if cfg := app.liveConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &app.config
+}
+
+// configValues returns the value of every setting in fs, keyed by name, as strings.
+func configValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if !isMetaSetting(f.Name) {
+			values[f.Name] = f.Value.String()
+		}
+	})
+
+	return values
+}
+
+// reloadConfig reads the configuration again, in the same way as at startup, and
+// atomically swaps in the new values of the reloadable settings. If the new
+// configuration is invalid, it's rejected as a whole and the server carries on with the
+// current one.
+func (app *application) reloadConfig() {
+	cfg, fs, err := loadConfig(os.Args[1:])
+	if err != nil {
+		app.logger.PrintError(err, map[string]any{"action": "reload configuration"})
+		return
+	}
+
+	values := configValues(fs)
+
+	changed := make(map[string]any)
+	var restartRequired []string
+
+	for name, value := range values {
+		old := app.configValues[name]
+		if value == old {
+			// A setting needing a restart may have been changed back to the value in
+			// use, in which case there's nothing pending any more.
+			delete(app.pendingValues, name)
+			continue
+		}
+
+		// A change to a setting which needs a restart is only reported by the first
+		// reload which sees it, rather than by every reload until the restart.
+		if !reloadableSettings[name] {
+			if app.pendingValues[name] != value {
+				app.pendingValues[name] = value
+				restartRequired = append(restartRequired, name)
+			}
+			continue
+		}
+
+		changed[name] = map[string]string{"from": old, "to": value}
+		app.configValues[name] = value
+	}
+
+	sort.Strings(restartRequired)
+
+	// Start from a copy of the current configuration and copy across only the
+	// reloadable settings, so that settings which need a restart keep the values the
+	// server is actually using.
+	next := *app.settings()
+	next.limiter.rps = cfg.limiter.rps
+	next.limiter.burst = cfg.limiter.burst
+	next.limiter.enabled = cfg.limiter.enabled
+	next.limiter.routes = cfg.limiter.routes
+	next.log.level = cfg.log.level
+	next.log.traces = cfg.log.traces
+	next.accessLog = cfg.accessLog
+	next.compress = cfg.compress
+	next.json = cfg.json
+	next.cors = cfg.cors
+	next.timeouts = cfg.timeouts
+
+	app.liveConfig.Store(&next)
+
+	// The log settings live in the logger, so apply them there. The level was checked
+	// by validate(), so ParseLevel() can't fail here.
+	level, _ := jsonlog.ParseLevel(next.log.level)
+	app.logger.SetLevel(level)
+
+	if next.log.traces {
+		app.logger.SetTraceLevel(jsonlog.LevelError)
+	} else {
+		app.logger.SetTraceLevel(jsonlog.LevelOff)
+	}
+
+	app.logger.PrintInfo("configuration reloaded", map[string]any{
+		"changed":          changed,
+		"restart_required": restartRequired,
+	})
+}
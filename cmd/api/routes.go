@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -13,24 +14,119 @@ func (app *application) routes() http.Handler {
 	// Convert the notFoundResponse() helper to a http.Handler using the
 	// http.HandlerFunc() adapter, and then set it as the custom error handler for 404
 	// Not Found responses.
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	// These are rate limited like the routes, so they get the RateLimit-* headers too,
+	// and probing for URLs counts against the client's limit.
+	router.NotFound = app.rateLimit(app.notFoundResponse)
 
 	// Likewise, convert the methodNotAllowedResponse() helper to a http.Handler and set
 	// it as the custom error handler for 405 Method Not Allowed responses.
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	router.MethodNotAllowed = app.rateLimit(app.methodNotAllowedResponse)
 
 	// Register the relevant methods, URL patterns and handler functions for our
 	// endpoints using the HandlerFunc() method. Note that http.MethodGet and
 	// http.MethodPost are constants which equate to the strings "GET" and "POST"
-	// respectively.
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.listMovieHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-
-	// Return the http-router instance with recoverPanic method Middleware
-	return app.recoverPanic(app.rateLimit(router))
+	// respectively. The handle() helper also records the matched pattern in the
+	// request context, so that metrics can be labelled by route, and applies the rate
+	// limit policy and the timeout for the route. The routes which use movieFixedOrID()
+	// are registered with dispatch() instead, because those are applied once the final
+	// route is known.
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.withRoutePattern(pattern, app.routeMiddleware(handler)))
+	}
+	dispatch := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.withRoutePattern(pattern, handler))
+	}
+
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodPost, "/v1/movies", app.requireJSON(app.createMovieHandler))
+	dispatch(http.MethodPost, "/v1/movies/:id", app.movieFixedOrID(app.methodNotAllowedResponse, map[string]http.HandlerFunc{
+		"import": app.importMovieHandler,
+		"batch":  app.requireJSON(app.batchMovieHandler),
+	}))
+	dispatch(http.MethodGet, "/v1/movies/:id", app.movieFixedOrID(app.showMovieHandler, map[string]http.HandlerFunc{
+		"export": app.exportMovieHandler,
+		"trash":  app.listTrashHandler,
+	}))
+	handle(http.MethodPatch, "/v1/movies/:id", app.requireJSON(app.updateMovieHandler))
+	handle(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	handle(http.MethodGet, "/v1/movies", app.listMovieHandler)
+	handle(http.MethodGet, "/v1/movies/:id/revisions", app.listMovieRevisionsHandler)
+	handle(http.MethodPost, "/v1/movies/:id/revisions/:version/restore", app.restoreMovieRevisionHandler)
+	handle(http.MethodPost, "/v1/movies/:id/restore", app.restoreMovieHandler)
+	handle(http.MethodPost, "/v1/users", app.requireJSON(app.registerUserHandler))
+
+	// The expvar endpoint exposes internal details, so it's only served on the public
+	// router in development. Elsewhere it's available on a loopback admin listener.
+	if app.config.env == "development" {
+		handle(http.MethodGet, "/debug/vars", expvar.Handler().ServeHTTP)
+	}
+
+	// Return the http-router instance with recoverPanic method Middleware. The tracing,
+	// request ID, access log and metrics middleware go outermost, so that they also see
+	// the responses sent by recoverPanic and rateLimit. Outside all of them, realIP
+	// works out the client's IP address for them to use. compress sits just outside
+	// recoverPanic, so that error responses are compressed too and the metrics and
+	// access log record the number of bytes actually sent. enableCORS goes outside
+	// compress, so that it can answer preflight requests before they reach the router,
+	// and secureHeaders outside that, so that every response gets its headers.
+	return app.realIP(app.traceRequests(app.requestID(app.logRequests(app.recordMetrics(
+		app.secureHeaders(app.enableCORS(app.compress(app.recoverPanic(router)))))))))
+}
+
+// httprouter doesn't allow a fixed path segment such as /v1/movies/export to share a
+// position with the /v1/movies/:id wildcard for the same method. So we register the
+// wildcard route only, and use movieFixedOrID() to dispatch the fixed names to their
+// own handlers before falling through to the handler for a movie ID. Each handler is
+// rate limited and timed out under its own route.
+func (app *application) movieFixedOrID(
+	idHandler http.HandlerFunc,
+	fixed map[string]http.HandlerFunc,
+) http.HandlerFunc {
+	idHandler = app.routeMiddleware(idHandler)
+	for name, handler := range fixed {
+		fixed[name] = app.routeMiddleware(handler)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if name := params.ByName("id"); fixed[name] != nil {
+			fixed[name](w, app.contextSetRoute(r, "/v1/movies/"+name))
+			return
+		}
+
+		idHandler(w, r)
+	}
+}
+
+// routeMiddleware wraps a handler in the middleware which depends on the matched route.
+// The deadline is set inside the rate limiter, so that a refused request doesn't start
+// one, and time spent waiting on the Postgres rate limit store doesn't count.
+func (app *application) routeMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return app.rateLimit(app.timeout(next))
+}
+
+// withRoutePattern wraps a handler so that the route pattern it was registered under is
+// recorded in the request context before it runs.
+func (app *application) withRoutePattern(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, app.contextSetRoute(r, pattern))
+	}
+}
+
+// adminRoutes returns the handler for the admin listener, which serves operational
+// endpoints that shouldn't be exposed on the public API port.
+func (app *application) adminRoutes() http.Handler {
+	router := httprouter.New()
+
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	router.Handler(http.MethodGet, "/metrics", app.metrics.registry.Handler())
+
+	if app.config.env == "development" || isLoopbackAddr(app.config.admin.addr) {
+		router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	}
+
+	return app.recoverPanic(router)
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+)
+
+// secureHeaders sets the security headers on every response, and refuses requests with
+// an overlong URL.
+//
+// The API only ever sends JSON (and the CSV and NDJSON exports), so the Content
+// Security Policy doesn't allow anything to be loaded or run at all, or the response to
+// be framed. That way, if a response is ever opened in a browser, nothing in it can be
+// executed. Strict-Transport-Security is only sent in production, where the API is
+// always served over HTTPS; sending it from a development machine would make the
+// browser refuse plain HTTP to localhost for a long time.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "deny")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		if app.config.env == "production" {
+			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+
+		// The server's MaxHeaderBytes limits the size of the request line and headers
+		// together, but the URL can be limited more tightly on its own: no legitimate
+		// request to the API needs a long one.
+		if len(r.RequestURI) > app.config.http.maxURLLength {
+			app.uriTooLongResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireJSON refuses requests to a JSON endpoint whose body isn't declared to be JSON
+// with a 415 Unsupported Media Type response, rather than trying to decode a form post
+// or a file upload as JSON. Requests without a body are let through, so that the
+// handler can report that the body is missing.
+func (app *application) requireJSON(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength == 0 && len(r.TransferEncoding) == 0 {
+			next(w, r)
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			app.unsupportedMediaTypeResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
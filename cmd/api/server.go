@@ -19,31 +19,123 @@ func (app *application) server() error {
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		// Requests with a larger request line and headers than this get a 431 Request
+		// Header Fields Too Large response from the server itself.
+		MaxHeaderBytes: app.config.http.maxHeaderBytes,
 	}
+	// When TLS is enabled, the certificate is loaded up front, so that the server
+	// refuses to start without a usable one, and then watched for renewals.
+	if app.config.tlsEnabled() {
+		certs, err := newCertReloader(app.config.tls.certFile, app.config.tls.keyFile, app.logger)
+		if err != nil {
+			return err
+		}
+		certs.watch(app.config.tls.reloadInterval)
+
+		srv.TLSConfig = app.tlsConfig(certs)
+	}
+
+	// The admin and redirect servers are optional; adminServer() and redirectServer()
+	// return nil if they're disabled.
+	adminSrv := app.adminServer()
+	redirectSrv := app.redirectServer()
+
 	shutdownError := make(chan error)
 
 	go func() {
 
 		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-		s := <-quit
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-		app.logger.PrintInfo("shutting down server ", map[string]string{
+		// SIGHUP reloads the configuration rather than shutting down, so keep waiting
+		// until we get one of the other signals.
+		var s os.Signal
+		for s = range quit {
+			if s != syscall.SIGHUP {
+				break
+			}
+			app.reloadConfig()
+		}
+
+		app.logger.PrintInfo("shutting down server ", map[string]any{
 			"signal": s.String(),
 		})
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		shutdownError <- srv.Shutdown(ctx)
+		// Every server is shut down, and the spans and logs flushed, even if an earlier
+		// step fails; all the errors are reported together. In particular, the main
+		// server must always be shut down, or ListenAndServe() below never returns.
+		var errs []error
+
+		for _, other := range []*http.Server{adminSrv, redirectSrv} {
+			if other == nil {
+				continue
+			}
+			errs = append(errs, other.Shutdown(ctx))
+		}
+
+		errs = append(errs, srv.Shutdown(ctx))
+
+		// No more requests will be rate limited, so stop the limiter's cleanup.
+		app.limiter.stop()
+
+		// Flush any spans which are still waiting to be exported.
+		if app.tracer != nil {
+			errs = append(errs, app.tracer.Shutdown(ctx))
+		}
+
+		// Write out any log entries from the final requests which are still queued.
+		errs = append(errs, app.logger.Flush())
+
+		shutdownError <- errors.Join(errs...)
 
 	}()
 
-	app.logger.PrintInfo("Server Successfully Start..", map[string]string{
+	app.logger.PrintInfo("Server Successfully Start..", map[string]any{
 		"addr": srv.Addr,
 		"env":  app.config.env,
+		"tls":  srv.TLSConfig != nil,
 	})
 
-	err := srv.ListenAndServe()
+	if adminSrv != nil {
+		go func() {
+			app.logger.PrintInfo("admin server started", map[string]any{
+				"addr": adminSrv.Addr,
+			})
+
+			err := adminSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]any{
+					"addr": adminSrv.Addr,
+				})
+			}
+		}()
+	}
+
+	if redirectSrv != nil {
+		go func() {
+			app.logger.PrintInfo("HTTPS redirect server started", map[string]any{
+				"addr": redirectSrv.Addr,
+			})
+
+			err := redirectSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]any{
+					"addr": redirectSrv.Addr,
+				})
+			}
+		}()
+	}
+
+	// The certificate comes from the TLS config, so ListenAndServeTLS() is given no
+	// file names.
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -52,9 +144,26 @@ func (app *application) server() error {
 		return err
 	}
 
-	app.logger.PrintInfo("Server Stopped", map[string]string{
+	app.logger.PrintInfo("Server Stopped", map[string]any{
 		"addr": srv.Addr,
 	})
 
-	return nil
+	// Close the logger's outputs, writing anything still queued.
+	return app.logger.Close()
+}
+
+// adminServer returns the http.Server for the admin listener, or nil if no admin
+// address has been configured.
+func (app *application) adminServer() *http.Server {
+	if app.config.admin.addr == "" {
+		return nil
+	}
+
+	return &http.Server{
+		Addr:         app.config.admin.addr,
+		Handler:      app.adminRoutes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
 }
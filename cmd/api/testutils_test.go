@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+)
+
+// newTestApplication returns an application using the default settings, without a
+// database, and with a logger which discards everything. modify, if not nil, can change
+// the settings before the application is created.
+func newTestApplication(t *testing.T, modify func(cfg *config)) *application {
+	t.Helper()
+
+	var cfg config
+	fs := cfg.flagSet()
+
+	err := fs.Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if modify != nil {
+		modify(&cfg)
+	}
+
+	limiter := newMemoryRateLimiter()
+	t.Cleanup(limiter.stop)
+
+	return &application{
+		config:        cfg,
+		configValues:  configValues(fs),
+		pendingValues: make(map[string]string),
+		logger:        jsonlog.New(io.Discard, jsonlog.LevelOff),
+		metrics:       newAppMetrics(nil),
+		limiter:       limiter,
+	}
+}
+
+// serve sends the request to h and returns the recorded response.
+func serve(h http.Handler, r *http.Request) *http.Response {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr.Result()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// routeTimeouts holds the per-route overrides of the default handler timeout, keyed by
+// "METHOD /pattern" (the method may be "*" to match any), in the same way as
+// routeLimits. It's a flag.Value, set from a space-separated list of
+// METHOD:PATTERN=DURATION entries, for example:
+//
+//	-route-timeouts="GET:/v1/movies/export=0 POST:/v1/movies/batch=30s"
+//
+// A duration of 0 turns the timeout off for the route.
+type routeTimeouts map[string]time.Duration
+
+func (rt *routeTimeouts) String() string {
+	entries := make([]string, 0, len(*rt))
+	for name, timeout := range *rt {
+		method, pattern, _ := strings.Cut(name, " ")
+		entries = append(entries, fmt.Sprintf("%s:%s=%s", method, pattern, timeout))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, " ")
+}
+
+func (rt *routeTimeouts) Set(val string) error {
+	timeouts := make(routeTimeouts)
+
+	for _, entry := range strings.Fields(val) {
+		method, rest, ok := strings.Cut(entry, ":")
+		if !ok {
+			return fmt.Errorf("%q: must be METHOD:PATTERN=DURATION", entry)
+		}
+
+		// Split on the last "=", since only the duration comes after it.
+		i := strings.LastIndex(rest, "=")
+		if i < 0 {
+			return fmt.Errorf("%q: must be METHOD:PATTERN=DURATION", entry)
+		}
+		pattern, value := rest[:i], rest[i+1:]
+
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("%q: the route pattern must start with /", entry)
+		}
+
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("%q: the timeout must be a duration such as 5s, or 0 for none", entry)
+		}
+
+		timeouts[strings.ToUpper(method)+" "+pattern] = timeout
+	}
+
+	*rt = timeouts
+	return nil
+}
+
+// timeoutFor returns the handler timeout for a route: an override for the method and
+// pattern if there is one, then an override for any method, then the default.
+func (cfg config) timeoutFor(method, pattern string) time.Duration {
+	if timeout, ok := cfg.timeouts.routes[method+" "+pattern]; ok {
+		return timeout
+	}
+	if timeout, ok := cfg.timeouts.routes["* "+pattern]; ok {
+		return timeout
+	}
+	return cfg.timeouts.handler
+}
+
+// timeout gives each request a deadline, according to the timeout for its route. The
+// deadline is carried by the request context, which the data layer passes on to every
+// query, so once it has passed any query in progress is cancelled and the handler gets
+// an error back, which serverErrorResponse() turns into a 503 Service Unavailable
+// response.
+//
+// Unlike http.TimeoutHandler, this doesn't buffer the response or cut the handler off
+// while it's running, so streaming responses such as the export still work. The
+// handler stops at its next query instead.
+func (app *application) timeout(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The timeouts can be changed by a configuration reload, so read them for each
+		// request.
+		timeout := app.settings().timeoutFor(r.Method, app.contextGetRoutePattern(r))
+
+		if timeout <= 0 {
+			next(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next(w, r.WithContext(ctx))
+	}
+}
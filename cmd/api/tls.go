@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+)
+
+// tlsVersions maps the values of the tls-min-version setting to the tls package's
+// version numbers. Versions before 1.2 are insecure, so they aren't offered.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteID returns the ID of the secure cipher suite with the given name, such as
+// "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256".
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// isHTTP2CipherSuite reports whether name is one of the cipher suites which HTTP/2
+// requires TLS 1.2 servers to support.
+func isHTTP2CipherSuite(name string) bool {
+	return name == "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" || name == "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+}
+
+// tlsEnabled reports whether the API server should serve HTTPS.
+func (cfg config) tlsEnabled() bool {
+	return cfg.tls.certFile != ""
+}
+
+// tlsConfig returns the TLS configuration for the API server, taking its certificate
+// from certs. The cipher suites only apply to TLS 1.2: Go doesn't allow the TLS 1.3
+// suites to be configured, because they're all secure.
+func (app *application) tlsConfig(certs *certReloader) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tlsVersions[app.config.tls.minVersion],
+		GetCertificate: certs.GetCertificate,
+	}
+
+	for _, name := range app.config.tls.cipherSuites {
+		id, _ := cipherSuiteID(name)
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+
+	return cfg
+}
+
+// certReloader holds the API server's certificate, and reloads it when the certificate
+// or key file changes, so that a renewed certificate is picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *jsonlog.Logger
+
+	cert atomic.Pointer[tls.Certificate]
+
+	mu      sync.Mutex
+	modTime time.Time // The latest modification time of the two files when last loaded.
+}
+
+// newCertReloader loads the certificate and key, returning an error if they can't be
+// used, so that the server doesn't start without a valid certificate.
+func newCertReloader(certFile, keyFile string, logger *jsonlog.Logger) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cr.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// GetCertificate is used as the tls.Config GetCertificate callback, so every new
+// connection gets the current certificate.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cr.cert.Load(), nil
+}
+
+// latestModTime returns the later of the modification times of the certificate and key
+// files.
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// load reads the certificate and key files and swaps them in.
+func (cr *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+
+	cr.mu.Lock()
+	cr.modTime = modTime
+	cr.mu.Unlock()
+
+	cr.cert.Store(&cert)
+
+	cr.logger.PrintInfo("TLS certificate loaded", map[string]any{
+		"subject":   leaf.Subject.String(),
+		"not_after": leaf.NotAfter.UTC().Format(time.RFC3339),
+	})
+
+	return nil
+}
+
+// watch launches a background goroutine which checks the certificate and key files for
+// changes at the given interval, and reloads them when they've changed. Certificate
+// tools usually replace the two files one after the other, so a reload can fail if it
+// happens in between. In that case the current certificate is kept and the error is
+// logged; the reload is tried again on the next check, since the files will still look
+// changed.
+func (cr *certReloader) watch(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			modTime, err := cr.latestModTime()
+			if err != nil {
+				cr.logger.PrintError(err, map[string]any{"action": "check TLS certificate"})
+				continue
+			}
+
+			cr.mu.Lock()
+			changed := !modTime.Equal(cr.modTime)
+			cr.mu.Unlock()
+
+			if !changed {
+				continue
+			}
+
+			err = cr.load(modTime)
+			if err != nil {
+				cr.logger.PrintError(err, map[string]any{"action": "reload TLS certificate"})
+			}
+		}
+	}()
+}
+
+// redirectServer returns the http.Server for the plain HTTP listener which redirects
+// every request to HTTPS, or nil if it's disabled.
+func (app *application) redirectServer() *http.Server {
+	if app.config.tls.redirectAddr == "" {
+		return nil
+	}
+
+	return &http.Server{
+		Addr:         app.config.tls.redirectAddr,
+		Handler:      http.HandlerFunc(app.redirectToHTTPS),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+}
+
+// redirectToHTTPS redirects a request to the same URL on the HTTPS port. GET and HEAD
+// requests get a 301, which every client follows. Anything else gets a 308, so the
+// client repeats the request with the same method and body rather than switching to
+// GET.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	host = strings.Trim(host, "[]")
+
+	if host == "" {
+		app.badRequestResponse(w, r, fmt.Errorf("the request must have a Host header"))
+		return
+	}
+
+	if app.config.port != 443 {
+		host = net.JoinHostPort(host, fmt.Sprint(app.config.port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	status := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		status = http.StatusMovedPermanently
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+}
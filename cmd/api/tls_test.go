@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+)
+
+// writeTestCert generates a self-signed certificate for commonName and writes it and
+// its key as PEM files in dir, returning their paths.
+func writeTestCert(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	logger := jsonlog.New(io.Discard, jsonlog.LevelOff)
+
+	certFile, keyFile := writeTestCert(t, dir, "old.example.com")
+
+	cr, err := newCertReloader(certFile, keyFile, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafName := func() string {
+		cert, err := cr.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+
+	if got := leafName(); got != "old.example.com" {
+		t.Fatalf("got certificate for %q; want %q", got, "old.example.com")
+	}
+
+	cr.watch(10 * time.Millisecond)
+
+	// Replace the files. Some filesystems only keep modification times to the second,
+	// so move them on explicitly to be sure the change is seen.
+	writeTestCert(t, dir, "new.example.com")
+
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		err := os.Chtimes(name, later, later)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for leafName() != "new.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate not reloaded; still for %q", leafName())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertReloaderKeepsCertificateOnBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	logger := jsonlog.New(io.Discard, jsonlog.LevelOff)
+
+	certFile, keyFile := writeTestCert(t, dir, "old.example.com")
+
+	cr, err := newCertReloader(certFile, keyFile, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr.watch(10 * time.Millisecond)
+
+	// A half-written certificate file can't be loaded, so the old one stays in use.
+	err = os.WriteFile(certFile, []byte("-----BEGIN CERTIFICATE-----\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	time.Sleep(50 * time.Millisecond)
+
+	cert, _ := cr.GetCertificate(nil)
+	if got := cert.Leaf.Subject.CommonName; got != "old.example.com" {
+		t.Fatalf("got certificate for %q; want %q", got, "old.example.com")
+	}
+}
+
+func TestNewCertReloaderMissingFiles(t *testing.T) {
+	logger := jsonlog.New(io.Discard, jsonlog.LevelOff)
+	dir := t.TempDir()
+
+	_, err := newCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), logger)
+	if err == nil {
+		t.Fatal("got no error for missing certificate files")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name         string
+		port         int
+		method       string
+		host         string
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name: "GET", port: 443, method: http.MethodGet,
+			host: "api.example.com", target: "/v1/movies?page=2",
+			wantStatus: http.StatusMovedPermanently, wantLocation: "https://api.example.com/v1/movies?page=2",
+		},
+		{
+			name: "HEAD", port: 443, method: http.MethodHead,
+			host: "api.example.com", target: "/v1/movies",
+			wantStatus: http.StatusMovedPermanently, wantLocation: "https://api.example.com/v1/movies",
+		},
+		{
+			name: "POST", port: 443, method: http.MethodPost,
+			host: "api.example.com", target: "/v1/movies",
+			wantStatus: http.StatusPermanentRedirect, wantLocation: "https://api.example.com/v1/movies",
+		},
+		{
+			name: "host with the plain HTTP port", port: 443, method: http.MethodGet,
+			host: "api.example.com:80", target: "/v1/healthcheck",
+			wantStatus: http.StatusMovedPermanently, wantLocation: "https://api.example.com/v1/healthcheck",
+		},
+		{
+			name: "non-standard HTTPS port", port: 8443, method: http.MethodGet,
+			host: "api.example.com:8080", target: "/v1/healthcheck",
+			wantStatus: http.StatusMovedPermanently, wantLocation: "https://api.example.com:8443/v1/healthcheck",
+		},
+		{
+			name: "IPv6", port: 443, method: http.MethodGet,
+			host: "[2001:db8::1]:80", target: "/v1/healthcheck",
+			wantStatus: http.StatusMovedPermanently, wantLocation: "https://[2001:db8::1]/v1/healthcheck",
+		},
+		{
+			name: "IPv6 without a port", port: 443, method: http.MethodPost,
+			host: "[2001:db8::1]", target: "/v1/movies",
+			wantStatus: http.StatusPermanentRedirect, wantLocation: "https://[2001:db8::1]/v1/movies",
+		},
+		{
+			name: "IPv6 on a non-standard HTTPS port", port: 8443, method: http.MethodGet,
+			host: "[2001:db8::1]:8080", target: "/",
+			wantStatus: http.StatusMovedPermanently, wantLocation: "https://[2001:db8::1]:8443/",
+		},
+		{
+			name: "no host", port: 443, method: http.MethodGet,
+			host: "", target: "/",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, func(cfg *config) {
+				cfg.port = tt.port
+			})
+
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.Host = tt.host
+
+			res := serve(http.HandlerFunc(app.redirectToHTTPS), r)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+			if got := res.Header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("got Location %q; want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestValidateCipherSuites(t *testing.T) {
+	tests := []struct {
+		name       string
+		minVersion string
+		suites     []string
+		wantErr    string
+	}{
+		{
+			name:       "defaults",
+			minVersion: "1.2",
+		},
+		{
+			name:       "includes an HTTP/2 suite",
+			minVersion: "1.2",
+			suites:     []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		},
+		{
+			name:       "no HTTP/2 suite",
+			minVersion: "1.2",
+			suites:     []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+			wantErr:    "for HTTP/2",
+		},
+		{
+			name:       "TLS 1.3 only",
+			minVersion: "1.3",
+			suites:     []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+		},
+		{
+			name:       "unknown suite",
+			minVersion: "1.2",
+			suites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"},
+			wantErr:    "not a supported secure cipher suite",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, func(cfg *config) {
+				cfg.tls.minVersion = tt.minVersion
+				cfg.tls.cipherSuites = tt.suites
+			})
+
+			err := app.config.validate()
+
+			var got string
+			if err != nil {
+				for _, line := range strings.Split(err.Error(), "\n") {
+					if strings.Contains(line, "tls-cipher-suites") {
+						got = line
+					}
+				}
+			}
+
+			switch {
+			case tt.wantErr == "" && got != "":
+				t.Errorf("got error %q; want none", got)
+			case tt.wantErr != "" && !strings.Contains(got, tt.wantErr):
+				t.Errorf("got error %q; want one containing %q", got, tt.wantErr)
+			}
+		})
+	}
+}
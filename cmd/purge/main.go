@@ -0,0 +1,73 @@
+// The purge command permanently removes movies which have been in the trash for longer
+// than the retention period. It's intended to be run periodically, for example from
+// cron:
+//
+//	go run ./cmd/purge -retention=720h
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/Ramdoni007/21Cinema/internal/data"
+	"github.com/Ramdoni007/21Cinema/internal/jsonlog"
+)
+
+func main() {
+	var (
+		dsn       string
+		retention time.Duration
+	)
+
+	// The DSN defaults to the same CINEMA_DB_DSN environment variable the API reads.
+	flag.StringVar(&dsn, "db-dsn", os.Getenv("CINEMA_DB_DSN"), "Postgresql DSN")
+	flag.DurationVar(&retention, "retention", 30*24*time.Hour, "Purge movies deleted longer ago than this")
+
+	flag.Parse()
+
+	if dsn == "" {
+		fmt.Fprintln(os.Stderr, "a DSN must be provided with -db-dsn or CINEMA_DB_DSN")
+		os.Exit(2)
+	}
+
+	// A zero or negative retention would purge everything in the trash, including
+	// movies deleted moments ago, so it's almost certainly a mistake.
+	if retention <= 0 {
+		fmt.Fprintln(os.Stderr, "-retention must be greater than zero")
+		os.Exit(2)
+	}
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	models := data.NewModel(db)
+
+	purged, err := models.Movies.Purge(context.Background(), retention)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	logger.PrintInfo("trash purged", map[string]any{
+		"retention": retention,
+		"purged":    purged,
+	})
+}
@@ -8,4 +8,8 @@ require github.com/lib/pq v1.10.9
 
 require golang.org/x/time v0.3.0
 
-require golang.org/x/crypto v0.13.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/crypto v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,231 @@
+package data
+
+import (
+	"container/list"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MovieCache is a bounded, in-process cache of the results of MovieModel.Get() and
+// MovieModel.GetAll(). When it's full, the least recently used entry is evicted, and
+// entries expire after a fixed TTL in any case.
+//
+// Changes made through MovieModel invalidate the affected entries as soon as they're
+// committed. Changes made by another instance of the API sharing the database can't be
+// seen, though, so the TTL is also the longest time a stale result can be served.
+//
+// A nil *MovieCache is valid and caches nothing, so the cache can be disabled by simply
+// not creating one.
+type MovieCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // Most recently used at the front.
+
+	// generation is incremented by every invalidation. A result is only stored if no
+	// invalidation has happened since the lookup which missed, otherwise a read which
+	// raced with a write could put the old values back in the cache after the write
+	// had cleared them out.
+	generation uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// NewMovieCache returns an empty cache holding up to size entries for ttl each.
+func NewMovieCache(size int, ttl time.Duration) *MovieCache {
+	return &MovieCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Hits returns the number of lookups which were answered from the cache.
+func (c *MovieCache) Hits() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.hits.Load()
+}
+
+// Misses returns the number of lookups which had to go to the database.
+func (c *MovieCache) Misses() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.misses.Load()
+}
+
+// Len returns the number of entries currently in the cache.
+func (c *MovieCache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// get looks up key, and also returns the generation to pass to set() on a miss.
+func (c *MovieCache) get(key string) (any, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(elem)
+			c.hits.Add(1)
+			return entry.value, c.generation, true
+		}
+
+		c.remove(elem)
+	}
+
+	c.misses.Add(1)
+	return nil, c.generation, false
+}
+
+// set stores value under key, unless the cache has been invalidated since generation.
+func (c *MovieCache) set(key string, value any, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:     key,
+		value:   value,
+		expires: time.Now().Add(c.ttl),
+	})
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate removes the entries for the movies with the given IDs, and every cached
+// list, since a change to any movie can change which movies a list query returns.
+func (c *MovieCache) invalidate(ids ...int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for _, id := range ids {
+		if elem, ok := c.entries[movieCacheKey(id)]; ok {
+			c.remove(elem)
+		}
+	}
+
+	for key, elem := range c.entries {
+		if strings.HasPrefix(key, "list:") {
+			c.remove(elem)
+		}
+	}
+}
+
+// remove deletes an entry. The mutex must be held.
+func (c *MovieCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// cacheThrough returns the cached value for key if there is one, and otherwise calls
+// fetch and caches its result. Values are cloned on the way in and out of the cache,
+// since callers are free to modify what they get back (updateMovieHandler does, for
+// one). Errors, including ErrRecordNotFound, aren't cached.
+func cacheThrough[T any](c *MovieCache, key string, clone func(T) T, fetch func() (T, error)) (T, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	value, generation, ok := c.get(key)
+	if ok {
+		return clone(value.(T)), nil
+	}
+
+	result, err := fetch()
+	if err != nil {
+		return result, err
+	}
+
+	c.set(key, clone(result), generation)
+	return result, nil
+}
+
+func movieCacheKey(id int64) string {
+	return "movie:" + strconv.FormatInt(id, 10)
+}
+
+// listCacheKey returns the cache key for a GetAll() query. The parameters are
+// normalized first, so that queries which must return the same results share an entry:
+// the title search is case-insensitive and ignores extra whitespace, and the genres
+// filter matches movies having all the genres in any order.
+func listCacheKey(title string, genres []string, filters Filters) string {
+	genres = slices.Clone(genres)
+	slices.Sort(genres)
+	genres = slices.Compact(genres)
+
+	key, _ := json.Marshal([]any{
+		strings.Join(strings.Fields(strings.ToLower(title)), " "),
+		genres,
+		filters.Sort,
+		filters.Page,
+		filters.PageSize,
+	})
+
+	return "list:" + string(key)
+}
+
+// movieList is the cached result of a GetAll() query.
+type movieList struct {
+	movies   []*Movie
+	metadata Metadata
+}
+
+func (l movieList) clone() movieList {
+	movies := make([]*Movie, len(l.movies))
+	for i, movie := range l.movies {
+		movies[i] = movie.clone()
+	}
+	return movieList{movies: movies, metadata: l.metadata}
+}
+
+// clone returns a deep copy of the movie.
+func (movie *Movie) clone() *Movie {
+	c := *movie
+	c.Genres = slices.Clone(movie.Genres)
+	if movie.DeletedAt != nil {
+		deletedAt := *movie.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
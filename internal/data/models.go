@@ -1,8 +1,14 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/Ramdoni007/21Cinema/internal/trace"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -12,18 +18,115 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// IsCanceled reports whether err means that a query was stopped by its context, because
+// the deadline passed or the context was cancelled. lib/pq usually reports that as the
+// query_canceled error sent back by the server, rather than returning the context's
+// error, so errors.Is() alone doesn't catch every case.
+func IsCanceled(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so that the same query code can run
+// either directly against the connection pool or inside a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// tracedConn wraps a dbtx so that every query made through it is recorded as a span,
+// as a child of the span carried by the query's context. If the context doesn't carry
+// a span (for example in the purge command), nothing is recorded. The span is named
+// after op, which should be the model method making the query (e.g. "MovieModel.Get").
+type tracedConn struct {
+	db dbtx
+	op string
+}
+
+func (c tracedConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := c.startSpan(ctx, query)
+	result, err := c.db.ExecContext(ctx, query, args...)
+	span.RecordError(err)
+	span.End()
+	return result, err
+}
+
+// QueryContext runs a query which returns rows. Most of the work of a query like this
+// happens while the rows are read, so the span is left open until the rows are closed
+// or exhausted (see tracedRows).
+func (c tracedConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*tracedRows, error) {
+	ctx, span := c.startSpan(ctx, query)
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (c tracedConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := c.startSpan(ctx, query)
+	row := c.db.QueryRowContext(ctx, query, args...)
+	span.RecordError(row.Err())
+	span.End()
+	return row
+}
+
+// startSpan starts a span for a query, named "data." followed by the connection's op.
+func (c tracedConn) startSpan(ctx context.Context, query string) (context.Context, *trace.Span) {
+	ctx, span := trace.StartChild(ctx, "data."+c.op, trace.KindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.statement", strings.Join(strings.Fields(query), " "))
+
+	return ctx, span
+}
+
+// tracedRows is the *sql.Rows returned by tracedConn.QueryContext(). It ends the
+// query's span when Next() reports that there are no more rows, or when the rows are
+// closed, whichever comes first. Span.End() ignores every call after the first, so the
+// usual "defer rows.Close()" is still safe.
+type tracedRows struct {
+	*sql.Rows
+	span *trace.Span
+}
+
+func (r *tracedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.span.RecordError(r.Rows.Err())
+	r.span.End()
+	return false
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	r.span.End()
+	return err
+}
+
 // Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses
 type Models struct {
-	Movies MovieModel
-	Users  UserModel
+	Movies         MovieModel
+	MovieRevisions MovieRevisionModel
+	Users          UserModel
+	RateLimits     RateLimitModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
 // the initialized MovieModel.
 func NewModel(db *sql.DB) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
-		Users:  UserModel{DB: db},
+		Movies:         MovieModel{DB: db},
+		MovieRevisions: MovieRevisionModel{DB: db},
+		Users:          UserModel{DB: db},
+		RateLimits:     RateLimitModel{DB: db},
 	}
 }
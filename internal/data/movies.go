@@ -13,24 +13,109 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"-"`
+	Title     string     `json:"title"`
+	Year      int32      `json:"year,omitempty"`
+	Runtime   Runtime    `json:"runtime"`
+	Genres    []string   `json:"genres,omitempty"`
+	Version   int32      `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 // Define a MovieModel struct with type which wraps a sql.DB connection pool.
+// When tx is set (see Batch()), the queries run inside that transaction instead, and
+// the IDs of the movies it changes are collected in changed. The actor is recorded
+// against any revisions written by the model (see WithActor()). If Cache is set, reads
+// are cached there (see MovieCache).
 type MovieModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Cache   *MovieCache
+	tx      *sql.Tx
+	changed *[]int64
+	actor   string
+}
+
+// WithActor returns a copy of the model which records actor as the author of every
+// movie revision it writes.
+func (m MovieModel) WithActor(actor string) MovieModel {
+	m.actor = actor
+	return m
+}
+
+// conn returns the transaction the model is bound to, if any, or the connection pool.
+// Queries made through it are traced as op (see tracedConn).
+func (m MovieModel) conn(op string) tracedConn {
+	if m.tx != nil {
+		return tracedConn{m.tx, op}
+	}
+	return tracedConn{m.DB, op}
+}
+
+// cache returns the cache to use for reads. Reads inside a transaction must see its
+// uncommitted changes, so they bypass the cache.
+func (m MovieModel) cache() *MovieCache {
+	if m.tx != nil {
+		return nil
+	}
+	return m.Cache
+}
+
+// touch records that the movie with the given ID was changed by the transaction, so
+// its cache entries can be invalidated once the transaction commits.
+func (m MovieModel) touch(id int64) {
+	if m.changed != nil {
+		*m.changed = append(*m.changed, id)
+	}
+}
+
+// Batch runs fn inside a single transaction. The MovieModel passed to fn is bound to
+// that transaction, so every Insert(), Get(), Update() and Delete() call made through
+// it either commits together or not at all. If fn returns an error the transaction is
+// rolled back and that error is returned.
+func (m MovieModel) Batch(ctx context.Context, fn func(tx MovieModel) error) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var changed []int64
+
+	txModel := m
+	txModel.tx = tx
+	txModel.changed = &changed
+
+	err = fn(txModel)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Only invalidate the cache once the changes are committed. Doing it any earlier
+	// would let a concurrent read fetch the old values and cache them again.
+	m.Cache.invalidate(changed...)
+
+	return nil
 }
 
 // Add a placeholder method for inserting a new record in the movies table.
 // The Insert() method accepts a pointer to a movie struct, which should contain the
 // data for the new record.
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
+	// Every change to a movie writes a revision alongside it, so make sure we're inside
+	// a transaction before going any further.
+	if m.tx == nil {
+		return m.Batch(ctx, func(tx MovieModel) error { return tx.Insert(ctx, movie) })
+	}
+
 	// Define the SQL query for inserting a new record in the movies table and returning
 	// the system-generated data.
 	query := `INSERT INTO movies (title, year, runtime, genres)
@@ -42,19 +127,41 @@ func (m MovieModel) Insert(movie *Movie) error {
 	// make it nice and clear *what values are being used where* in the query.
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 
 	defer cancel()
 
 	// Use the QueryRowContext() method to execute the SQL query and ctx context Method on our connection pool,
 	// passing in the args slice as a variadic parameter and scanning the system-
 	// generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).
+	err := m.conn("MovieModel.Insert").QueryRowContext(ctx, query, args...).
 		Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	m.touch(movie.ID)
+
+	return m.recordRevision(ctx, RevisionInsert, nil, movie)
+}
+
+// InsertBatch inserts a group of movies inside a single transaction, so either every
+// movie in the batch is created or none of them are. As with Insert(), the
+// system-generated id, created_at and version values are scanned back into each movie
+// struct.
+func (m MovieModel) InsertBatch(ctx context.Context, movies []*Movie) error {
+	return m.Batch(ctx, func(tx MovieModel) error {
+		for _, movie := range movies {
+			if err := tx.Insert(ctx, movie); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // Add a placeholder method for fetching a specific record from the movies table
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	// The PostgresSQL bigserial type that we're using for the movie ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will have ID values
 	// less than that. To avoid making an unnecessary database call, we take a shortcut
@@ -64,18 +171,27 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		return nil, ErrRecordNotFound
 	}
 
+	return cacheThrough(m.cache(), movieCacheKey(id), (*Movie).clone, func() (*Movie, error) {
+		return m.get(ctx, id)
+	})
+}
+
+// get fetches a movie from the database, bypassing the cache.
+func (m MovieModel) get(ctx context.Context, id int64) (*Movie, error) {
 	// Define the SQL query for retrieving the movie data.
+	// Movies which have been moved to the trash are treated as though they don't exist.
 	query := `SELECT id, created_at, title, year, runtime, genres, version  
        FROM movies 
-       WHERE id = $1`
+       WHERE id = $1 AND deleted_at IS NULL`
 
 	// Declare a Movie struct to hold the data returned by the query.
 	var movie Movie
 
 	// Use the context.WithTimeout() function to create a context.Context which carries a
-	// 3-second timeout deadline. Note that we're using the empty context.Background()
-	// as the 'parent' context.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// 3-second timeout deadline. The 'parent' is the caller's context, usually the
+	// request context, so the query is also cancelled if the client goes away or the
+	// handler's own deadline passes first.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	// Importantly, use defer to make sure that we cancel the context before the Get()
 	// method returns.
 	defer cancel()
@@ -86,7 +202,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// genres column using the pq.Array() adapter function again.
 	// Use the QueryRowContext() method to execute the query, passing in the context
 	// with the deadline as the first argument.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.conn("MovieModel.Get").QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -112,13 +228,30 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 }
 
 // Add a placeholder method for updating a specific record in the movies table.
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
+	if m.tx == nil {
+		return m.Batch(ctx, func(tx MovieModel) error { return tx.Update(ctx, movie) })
+	}
+
+	// Lock the current row and keep hold of its values, so the revision can record
+	// what changed. If the row has gone, that's an edit conflict just like a version
+	// mismatch below.
+	previous, err := m.GetForUpdate(ctx, movie.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
 	// Declare the SQL query for updating the record and returning the new version
 	// number.
 	// Add the 'AND version = $6' clause to the SQL query. To handle Err Race Condition
 	query := ` 
       UPDATE movies SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-      WHERE id = $5 AND version = $6
+      WHERE id = $5 AND version = $6 AND deleted_at IS NULL
       RETURNING version`
 
 	// Create an args slice containing the values for the placeholder parameters.
@@ -131,7 +264,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version, // Add the expected movie version
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 
 	defer cancel()
 
@@ -140,7 +273,7 @@ func (m MovieModel) Update(movie *Movie) error {
 	// Execute the SQL query. If no matching row could be found, we know the movie AND
 	// version has changed (or the record has been deleted) and we return our custom
 	// ErrEditConflict error.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = m.conn("MovieModel.Update").QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -151,30 +284,44 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
+	m.touch(movie.ID)
+
+	return m.recordRevision(ctx, RevisionUpdate, previous, movie)
 }
 
 // Add a placeholder method for deleting a specific record from the movies table.
-func (m MovieModel) Delete(id int64) error {
+// Deleting a movie only moves it to the trash by setting deleted_at; the row itself
+// stays in place until it's restored with Restore() or removed for good by Purge().
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
+	if m.tx == nil {
+		return m.Batch(ctx, func(tx MovieModel) error { return tx.Delete(ctx, id) })
+	}
+
+	// Keep hold of the values being deleted for the revision history.
+	previous, err := m.GetForUpdate(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Construct the SQL query to move the record to the trash.
 	query := `
-      DELETE FROM movies
-      WHERE id = $1
+      UPDATE movies SET deleted_at = NOW()
+      WHERE id = $1 AND deleted_at IS NULL
   `
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 
 	defer cancel()
 
 	// Execute the SQL query using the Exec() method, passing in the id variable as
 	// the value for the placeholder parameter. The Exec() method returns a sql.Result
 	// object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.conn("MovieModel.Delete").ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -193,11 +340,34 @@ func (m MovieModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	m.touch(id)
+
+	return m.recordRevision(ctx, RevisionDelete, previous, nil)
 }
 
 // Update the function signature to return a Metadata struct.
 func (m MovieModel) GetAll(
+	ctx context.Context,
+	title string,
+	genres []string,
+	filters Filters,
+) ([]*Movie, Metadata, error) {
+	key := listCacheKey(title, genres, filters)
+
+	list, err := cacheThrough(m.cache(), key, movieList.clone, func() (movieList, error) {
+		movies, metadata, err := m.getAll(ctx, title, genres, filters)
+		return movieList{movies: movies, metadata: metadata}, err
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return list.movies, list.metadata, nil
+}
+
+// getAll runs a GetAll() query against the database, bypassing the cache.
+func (m MovieModel) getAll(
+	ctx context.Context,
 	title string,
 	genres []string,
 	filters Filters,
@@ -209,10 +379,11 @@ func (m MovieModel) GetAll(
     FROM movies
     WHERE (to_tsvector('simple',title) @@ plainto_tsquery('simple',$1)OR $1 = '')
     AND (genres @> $2 OR $2 = '{}')
+    AND deleted_at IS NULL
     ORDER BY %s %s, id ASC 
     LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// As our SQL query now has quite a few placeholder parameters, let's collect the
@@ -221,7 +392,7 @@ func (m MovieModel) GetAll(
 	// LIMIT and OFFSET clauses.
 	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.conn("MovieModel.GetAll").QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 	}
@@ -263,6 +434,236 @@ func (m MovieModel) GetAll(
 	return movies, metadata, nil
 }
 
+// Restore takes a movie back out of the trash. It returns ErrRecordNotFound if there
+// is no trashed movie with the given ID.
+func (m MovieModel) Restore(ctx context.Context, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	var movie *Movie
+
+	if m.tx == nil {
+		err := m.Batch(ctx, func(tx MovieModel) error {
+			var err error
+			movie, err = tx.Restore(ctx, id)
+			return err
+		})
+		return movie, err
+	}
+
+	query := `
+      UPDATE movies SET deleted_at = NULL
+      WHERE id = $1 AND deleted_at IS NOT NULL
+      RETURNING id, created_at, title, year, runtime, genres, version`
+
+	movie = &Movie{}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.conn("MovieModel.Restore").QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	m.touch(movie.ID)
+
+	// Record the restore with an empty diff: the values are exactly as they were when
+	// the movie was deleted.
+	err = m.recordRevision(ctx, RevisionRestore, movie, movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return movie, nil
+}
+
+// GetTrash returns a page of the movies which are currently in the trash, most
+// recently deleted first.
+func (m MovieModel) GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error) {
+	query := `
+    SELECT count(*) OVER(), id,created_at,title,year,runtime,genres,version,deleted_at
+    FROM movies
+    WHERE deleted_at IS NOT NULL
+    ORDER BY deleted_at DESC, id ASC
+    LIMIT $1 OFFSET $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.conn("MovieModel.GetTrash").QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Purge permanently removes the movies which were moved to the trash more than
+// olderThan ago, returning the number of rows removed. Their revision history is kept.
+func (m MovieModel) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	query := `
+      DELETE FROM movies
+      WHERE deleted_at IS NOT NULL AND deleted_at < NOW() - make_interval(secs => $1)`
+
+	// Purging a large trash can take a while, so allow more than the usual 3 seconds.
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	result, err := m.conn("MovieModel.Purge").ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// The number of rows pulled from the export cursor with each FETCH.
+const exportFetchSize = 500
+
+// Export walks every movie matching the title and genres filters, in the order given by
+// filters.Sort, and calls fn once per movie. Rather than loading the whole result set,
+// it declares a server-side cursor inside a read-only transaction and fetches the rows
+// in chunks, so memory use stays flat no matter how big the table is. If fn returns an
+// error the export stops and that error is returned. The Page and PageSize fields of
+// the filters are ignored.
+func (m MovieModel) Export(
+	ctx context.Context,
+	title string,
+	genres []string,
+	filters Filters,
+	fn func(*Movie) error,
+) error {
+	query := fmt.Sprintf(`
+    DECLARE movies_export NO SCROLL CURSOR FOR
+    SELECT id,created_at,title,year,runtime,genres,version
+    FROM movies
+    WHERE (to_tsvector('simple',title) @@ plainto_tsquery('simple',$1)OR $1 = '')
+    AND (genres @> $2 OR $2 = '{}')
+    AND deleted_at IS NULL
+    ORDER BY %s %s, id ASC`, filters.sortColumn(), filters.sortDirection())
+
+	// An export can legitimately run for a long time, so the transaction itself isn't
+	// given a deadline. Instead each statement below gets its own 3-second timeout. It
+	// is still cancelled along with the caller's context, so the export stops if the
+	// client goes away.
+	txCtx, txCancel := context.WithCancel(ctx)
+	defer txCancel()
+
+	tx, err := m.DB.BeginTx(txCtx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	declareCtx, cancel := context.WithTimeout(txCtx, 3*time.Second)
+	_, err = tracedConn{tx, "MovieModel.Export"}.ExecContext(declareCtx, query, title, pq.Array(genres))
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for {
+		movies, err := m.fetchExportChunk(txCtx, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range movies {
+			if err := fn(movie); err != nil {
+				return err
+			}
+		}
+
+		// A short chunk means the cursor has been exhausted.
+		if len(movies) < exportFetchSize {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+// fetchExportChunk reads the next chunk of rows from the movies_export cursor. The
+// chunk is read in full before it's handed back, so that a slow consumer can't push
+// the FETCH statement past its deadline.
+func (m MovieModel) fetchExportChunk(parent context.Context, tx *sql.Tx) ([]*Movie, error) {
+	ctx, cancel := context.WithTimeout(parent, 3*time.Second)
+	defer cancel()
+
+	rows, err := tracedConn{tx, "MovieModel.Export"}.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM movies_export", exportFetchSize))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := make([]*Movie, 0, exportFetchSize)
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	return movies, rows.Err()
+}
+
 // Passing Validation check to func ValidateMovie
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	// Use the Check() method to execute our validation checks. This will add the
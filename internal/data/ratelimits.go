@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RateLimitWindow holds the request counts for a key in the current fixed window and
+// the one before it, along with how far through the current window we are (from 0 to
+// 1). Together they're enough to estimate the number of requests in the sliding window
+// ending now.
+type RateLimitWindow struct {
+	Previous int64
+	Current  int64
+	Elapsed  float64
+}
+
+// Create a RateLimitModel struct which wraps the connection pool.
+type RateLimitModel struct {
+	DB *sql.DB
+}
+
+// Hit counts a request for key in the current window, and returns the counts for the
+// current and previous windows. Windows are numbered from the Unix epoch using the
+// database clock, so every instance of the API sharing the database agrees on where
+// the window boundaries are. The read and the increment happen in a single statement,
+// so concurrent requests can't both see the same count.
+func (m RateLimitModel) Hit(ctx context.Context, key string, window time.Duration) (RateLimitWindow, error) {
+	query := `
+        WITH clock AS (
+            SELECT extract(epoch FROM now())::double precision / $2 AS window_position
+        ), previous_window AS (
+            SELECT COALESCE(SUM(w.count), 0) AS count
+            FROM rate_limit_windows w, clock c
+            WHERE w.key = $1 AND w.window_index = floor(c.window_position)::bigint - 1
+        ), current_window AS (
+            INSERT INTO rate_limit_windows (key, window_index, count, expires_at)
+            SELECT $1, floor(c.window_position)::bigint, 1, now() + make_interval(secs => 2 * $2)
+            FROM clock c
+            ON CONFLICT (key, window_index) DO UPDATE SET count = rate_limit_windows.count + 1
+            RETURNING count
+        )
+        SELECT previous_window.count, current_window.count, c.window_position - floor(c.window_position)
+        FROM previous_window, current_window, clock c`
+
+	var hit RateLimitWindow
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := tracedConn{m.DB, "RateLimitModel.Hit"}.QueryRowContext(ctx, query, key, window.Seconds()).Scan(
+		&hit.Previous,
+		&hit.Current,
+		&hit.Elapsed,
+	)
+	if err != nil {
+		return RateLimitWindow{}, err
+	}
+
+	return hit, nil
+}
+
+// DeleteExpired removes the windows which are too old to be counted any more, and
+// returns the number of rows deleted.
+func (m RateLimitModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+        DELETE FROM rate_limit_windows
+        WHERE expires_at < now()`
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := tracedConn{m.DB, "RateLimitModel.DeleteExpired"}.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// newTestDB connects to the database named by the CINEMA_TEST_DB_DSN environment
+// variable, which must have the migrations applied. Tests which need a database are
+// skipped if it isn't set.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("CINEMA_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("CINEMA_TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestRateLimitModelHit(t *testing.T) {
+	db := newTestDB(t)
+	m := RateLimitModel{DB: db}
+
+	// Use a key of our own, so that the test can run against a shared database.
+	key := fmt.Sprintf("test|%s|%d", t.Name(), time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM rate_limit_windows WHERE key = $1", key)
+	})
+
+	// A day-long window, so that all the hits land in the same window unless the test
+	// happens to run across midnight UTC, when the windows start.
+	window := 24 * time.Hour
+
+	for want := int64(1); want <= 3; want++ {
+		hit, err := m.Hit(context.Background(), key, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hit.Current != want {
+			t.Errorf("hit %d: got current %d; want %d", want, hit.Current, want)
+		}
+		if hit.Previous != 0 {
+			t.Errorf("hit %d: got previous %d; want 0", want, hit.Previous)
+		}
+		if hit.Elapsed < 0 || hit.Elapsed >= 1 {
+			t.Errorf("hit %d: got elapsed %v; want from 0 up to 1", want, hit.Elapsed)
+		}
+	}
+
+	// The previous window's count is read back from the window before the current one.
+	_, err := db.Exec(`
+        INSERT INTO rate_limit_windows (key, window_index, count, expires_at)
+        SELECT $1, floor(extract(epoch FROM now()) / $2)::bigint - 1, 7, now() + interval '1 hour'`,
+		key, window.Seconds())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hit, err := m.Hit(context.Background(), key, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit.Previous != 7 || hit.Current != 4 {
+		t.Errorf("got previous %d, current %d; want 7, 4", hit.Previous, hit.Current)
+	}
+
+	// Windows from other keys aren't counted.
+	other, err := m.Hit(context.Background(), key+"|other", window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("DELETE FROM rate_limit_windows WHERE key = $1", key+"|other")
+
+	if other.Previous != 0 || other.Current != 1 {
+		t.Errorf("other key: got previous %d, current %d; want 0, 1", other.Previous, other.Current)
+	}
+}
+
+func TestRateLimitModelDeleteExpired(t *testing.T) {
+	db := newTestDB(t)
+	m := RateLimitModel{DB: db}
+
+	key := fmt.Sprintf("test|%s|%d", t.Name(), time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM rate_limit_windows WHERE key = $1", key)
+	})
+
+	_, err := db.Exec(`
+        INSERT INTO rate_limit_windows (key, window_index, count, expires_at)
+        VALUES ($1, 1, 1, now() - interval '1 minute'), ($1, 2, 1, now() + interval '1 hour')`,
+		key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining int
+	err = db.QueryRow("SELECT count(*) FROM rate_limit_windows WHERE key = $1", key).Scan(&remaining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 1 {
+		t.Errorf("got %d windows left; want 1", remaining)
+	}
+}
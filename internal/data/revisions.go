@@ -0,0 +1,256 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The actions that can be recorded in a movie revision.
+const (
+	RevisionInsert  = "insert"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+)
+
+// A MovieRevision records a single change to a movie: who made it, when, which fields
+// changed, and a snapshot of the movie as it stood after the change (or, for a delete,
+// as it stood just before it).
+type MovieRevision struct {
+	ID        int64                 `json:"id"`
+	MovieID   int64                 `json:"movie_id"`
+	Version   int32                 `json:"version"`
+	Action    string                `json:"action"`
+	ChangedBy string                `json:"changed_by"`
+	ChangedAt time.Time             `json:"changed_at"`
+	Diff      map[string]*FieldDiff `json:"diff"`
+	Movie     Movie                 `json:"movie"`
+}
+
+// FieldDiff holds the old and new values of a single changed field. From is nil for
+// an insert, and To is nil for a delete.
+type FieldDiff struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// diffMovies compares two versions of a movie field by field. Either side may be nil.
+func diffMovies(from, to *Movie) map[string]*FieldDiff {
+	fields := func(m *Movie) map[string]interface{} {
+		if m == nil {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{
+			"title":   m.Title,
+			"year":    m.Year,
+			"runtime": m.Runtime,
+			"genres":  m.Genres,
+		}
+	}
+
+	before, after := fields(from), fields(to)
+	diff := make(map[string]*FieldDiff)
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		// Comparing the JSON encodings is the simplest way to compare slices and the
+		// other field types uniformly.
+		b, _ := json.Marshal(before[name])
+		a, _ := json.Marshal(after[name])
+
+		if string(a) != string(b) {
+			diff[name] = &FieldDiff{From: before[name], To: after[name]}
+		}
+	}
+
+	return diff
+}
+
+// GetForUpdate fetches a movie and locks its row until the end of the transaction the
+// model is bound to, so it must be called on a model passed to the function given to
+// Batch(). Outside a transaction the lock is released as soon as it's taken.
+func (m MovieModel) GetForUpdate(ctx context.Context, id int64) (*Movie, error) {
+	query := `SELECT id, created_at, title, year, runtime, genres, version
+       FROM movies
+       WHERE id = $1 AND deleted_at IS NULL
+       FOR UPDATE`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.conn("MovieModel.GetForUpdate").QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// recordRevision writes a revision for a change from previous to current. For an
+// insert previous is nil, and for a delete current is nil.
+func (m MovieModel) recordRevision(ctx context.Context, action string, previous, current *Movie) error {
+	query := `
+      INSERT INTO movie_revisions (movie_id, version, action, changed_by, diff, title, year, runtime, genres)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	// The snapshot is the movie after the change, except for a delete where it's the
+	// movie as it was just before being removed.
+	snapshot := current
+	if snapshot == nil {
+		snapshot = previous
+	}
+
+	diff, err := json.Marshal(diffMovies(previous, current))
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{
+		snapshot.ID,
+		snapshot.Version,
+		action,
+		m.actor,
+		diff,
+		snapshot.Title,
+		snapshot.Year,
+		snapshot.Runtime,
+		pq.Array(snapshot.Genres),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err = m.conn("MovieModel.recordRevision").ExecContext(ctx, query, args...)
+	return err
+}
+
+// Define a MovieRevisionModel struct which wraps a sql.DB connection pool. Revisions are
+// written by MovieModel as part of each change, so this model only reads them.
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns a page of the revisions for a movie, oldest first.
+func (m MovieRevisionModel) GetAll(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := `
+    SELECT count(*) OVER(), id, movie_id, version, action, changed_by, changed_at, diff,
+           title, year, runtime, genres
+    FROM movie_revisions
+    WHERE movie_id = $1
+    ORDER BY id ASC
+    LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := tracedConn{m.DB, "MovieRevisionModel.GetAll"}.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := scanRevision(rows, &revision, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// Get returns the revision which produced a specific version of a movie. Deletes and
+// restores are skipped, since they don't produce a new version.
+func (m MovieRevisionModel) Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+    SELECT id, movie_id, version, action, changed_by, changed_at, diff,
+           title, year, runtime, genres
+    FROM movie_revisions
+    WHERE movie_id = $1 AND version = $2 AND action IN ('insert', 'update')
+    ORDER BY id DESC
+    LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var revision MovieRevision
+
+	err := scanRevision(tracedConn{m.DB, "MovieRevisionModel.Get"}.QueryRowContext(ctx, query, movieID, version), &revision)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// scanRevision scans a movie_revisions row (as selected by GetAll() and Get()) into
+// revision, decoding the stored diff and filling in the movie snapshot. Any leading
+// destinations are scanned first, for queries which select extra columns (such as a
+// window count) ahead of the revision columns.
+func scanRevision(row interface{ Scan(...interface{}) error }, revision *MovieRevision, leading ...interface{}) error {
+	var diff []byte
+
+	dest := append(leading,
+		&revision.ID,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Action,
+		&revision.ChangedBy,
+		&revision.ChangedAt,
+		&diff,
+		&revision.Movie.Title,
+		&revision.Movie.Year,
+		&revision.Movie.Runtime,
+		pq.Array(&revision.Movie.Genres),
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	revision.Movie.ID = revision.MovieID
+	revision.Movie.Version = revision.Version
+
+	return json.Unmarshal(diff, &revision.Diff)
+}
@@ -0,0 +1,159 @@
+package jsonlog
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQueueClosed is returned by AsyncWriter.Write after the writer has been closed.
+var ErrQueueClosed = errors.New("jsonlog: async writer is closed")
+
+// AsyncWriter queues entries in a bounded buffer and writes them to the underlying
+// writer from a background goroutine, so that a slow output (a blocked stdout pipe,
+// say) doesn't hold up the goroutines doing the logging. When the queue is full new
+// entries are dropped, and counted, rather than blocking.
+type AsyncWriter struct {
+	out   io.Writer
+	queue chan []byte
+	flush chan chan struct{}
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// NewAsyncWriter starts an AsyncWriter which holds up to size entries in its queue.
+func NewAsyncWriter(out io.Writer, size int) *AsyncWriter {
+	a := &AsyncWriter{
+		out:   out,
+		queue: make(chan []byte, size),
+		flush: make(chan chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+// Write queues a copy of p. It never blocks: if the queue is full the entry is dropped.
+// Because of that, a nil error doesn't mean the entry will definitely be written.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return 0, ErrQueueClosed
+	}
+
+	// The caller may reuse p once we return, so queue a copy.
+	entry := make([]byte, len(p))
+	copy(entry, p)
+
+	select {
+	case a.queue <- entry:
+	default:
+		a.dropped.Add(1)
+	}
+
+	return len(p), nil
+}
+
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+
+	for {
+		select {
+		case entry, ok := <-a.queue:
+			if !ok {
+				return
+			}
+			a.write(entry)
+		case ack := <-a.flush:
+			a.drain()
+			if f, ok := a.out.(flusher); ok {
+				f.Flush()
+			}
+			close(ack)
+		}
+	}
+}
+
+// drain writes whatever is currently queued.
+func (a *AsyncWriter) drain() {
+	for {
+		select {
+		case entry, ok := <-a.queue:
+			if !ok {
+				return
+			}
+			a.write(entry)
+		default:
+			return
+		}
+	}
+}
+
+func (a *AsyncWriter) write(entry []byte) {
+	_, err := a.out.Write(entry)
+	if err != nil {
+		a.dropped.Add(1)
+		return
+	}
+	a.written.Add(1)
+}
+
+// Flush blocks until every entry queued before the call has been written.
+func (a *AsyncWriter) Flush() error {
+	a.mu.RLock()
+	closed := a.closed
+	a.mu.RUnlock()
+
+	if closed {
+		return nil
+	}
+
+	ack := make(chan struct{})
+
+	select {
+	case a.flush <- ack:
+		<-ack
+	case <-a.done:
+	}
+
+	return nil
+}
+
+// Close writes any queued entries, stops the background goroutine and closes the
+// underlying writer if it's an io.Closer (other than stdout or stderr). Entries written
+// after Close are rejected.
+func (a *AsyncWriter) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	<-a.done
+
+	return closeWriter(a.out)
+}
+
+// Written returns the number of entries written to the underlying writer.
+func (a *AsyncWriter) Written() uint64 {
+	return a.written.Load()
+}
+
+// Dropped returns the number of entries which were discarded, either because the queue
+// was full or because the underlying writer returned an error.
+func (a *AsyncWriter) Dropped() uint64 {
+	return a.dropped.Load()
+}
@@ -3,10 +3,13 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,7 +19,9 @@ type Level int8
 // Initialize constants which represent a specific severity level. We use the iota
 // keyword as a shortcut to assign successive integer values to the constants.
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -25,76 +30,181 @@ const (
 // Return a human-friendly string for the severity level.
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 
 	}
 }
 
+// ParseLevel returns the Level with the given name, ignoring case. It accepts the names
+// returned by String(), so "debug", "info", "warn", "error", "fatal" or "off".
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("jsonlog: unknown level %q", s)
+}
+
+// Properties holds the additional fields for a log entry. Values can be of any type
+// which encoding/json can marshal, including nested maps and structs. As a convenience,
+// time.Duration values are written in their String() form ("1.5s") and errors as their
+// message, rather than as a number and an empty object.
+type Properties = map[string]any
+
+// core is the part of a Logger which is shared between a logger and the children
+// created by With(): the output destination, the mutex coordinating writes to it, the
+// levels and the redactor. Because it's shared, changing the level of any logger
+// changes it for the whole family.
+type core struct {
+	out        io.Writer
+	mu         sync.Mutex
+	minLevel   atomic.Int32
+	traceLevel atomic.Int32
+	redactor   atomic.Pointer[Redactor]
+}
+
 // Define a custom Logger type. This holds the output destination that the log entries
 // will be written to, the minimum severity level that log entries will be written for,
-// plus a mutex for coordinating the writes.
+// plus a mutex for coordinating the writes. The properties are included in every entry
+// written by the logger (see With()).
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	*core
+	properties Properties
 }
 
 // Return a new Logger instance which writes log entries at or above a minimum severity
-// level to a specific output destination.
+// level to a specific output destination. Stack traces are captured for entries at the
+// ERROR level and above; use SetTraceLevel() to change that. Sensitive data is masked
+// by a Redactor with the default rules; use SetRedactor() to change them.
 func New(out io.Writer, minLevel Level) *Logger {
+	c := &core{out: out}
+	c.minLevel.Store(int32(minLevel))
+	c.traceLevel.Store(int32(LevelError))
+	c.redactor.Store(NewRedactor())
+
+	return &Logger{core: c}
+}
+
+// With returns a child logger which adds the given properties to every entry it writes,
+// on top of any the parent already adds. The child shares the parent's output, mutex
+// and levels, so entries from both are never intermingled.
+func (l *Logger) With(properties Properties) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		core:       l.core,
+		properties: mergeProperties(l.properties, properties),
 	}
 }
 
-func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+// SetLevel changes the minimum severity level that entries are written for. It's safe
+// to call while the logger is in use.
+func (l *Logger) SetLevel(level Level) {
+	l.minLevel.Store(int32(level))
+}
+
+// Level returns the current minimum severity level.
+func (l *Logger) Level() Level {
+	return Level(l.minLevel.Load())
+}
+
+// SetTraceLevel changes the minimum severity level for which a stack trace is captured
+// and included in the entry. Capturing a stack isn't free, so passing LevelOff turns
+// it off entirely.
+func (l *Logger) SetTraceLevel(level Level) {
+	l.traceLevel.Store(int32(level))
+}
+
+// SetRedactor replaces the redactor used to mask sensitive data. Passing nil turns
+// redaction off, which should only be done when debugging locally.
+func (l *Logger) SetRedactor(r *Redactor) {
+	l.redactor.Store(r)
+}
+
+// Enabled reports whether an entry at the given level would be written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level() && level < LevelOff
 }
 
 // Declare some helper methods for writing log entries at the different levels. Notice
 // that these all accept a map as the second parameter which can contain any arbitrary
 // 'properties' that you want to appear in the log entry.
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
+func (l *Logger) PrintDebug(message string, properties Properties) {
+	l.print(LevelDebug, message, properties)
+}
+
+func (l *Logger) PrintInfo(message string, properties Properties) {
 	l.print(LevelInfo, message, properties)
 }
 
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
+func (l *Logger) PrintWarn(message string, properties Properties) {
+	l.print(LevelWarn, message, properties)
+}
+
+func (l *Logger) PrintError(err error, properties Properties) {
+	l.print(LevelError, err.Error(), properties)
+}
+
+func (l *Logger) PrintFatal(err error, properties Properties) {
 	l.print(LevelFatal, err.Error(), properties)
+	l.Flush()  // Make sure the entry isn't left in a queue when we exit.
 	os.Exit(1) // For entries at the FATAL level, we also terminate the application.
 }
 
 // Print is an internal method for writing the log entry.
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func (l *Logger) print(level Level, message string, properties Properties) (int, error) {
+	return l.printAt(time.Now(), level, message, properties)
+}
+
+// printAt writes a log entry stamped with the time t, for entries which were created
+// before they reach the logger, such as slog records.
+func (l *Logger) printAt(t time.Time, level Level, message string, properties Properties) (int, error) {
 	// If the severity level of the log entry is below the minimum severity for the
 	// logger, then return with no further action.
-	if level < l.minLevel {
+	if !l.Enabled(level) {
 		return 0, nil
 	}
 
+	// Merge in the logger's own properties. Properties passed for this entry take
+	// precedence over the logger's if the same key appears in both.
+	properties = mergeProperties(l.properties, properties)
+
+	// Mask any sensitive data before the entry is serialised, so it never reaches the
+	// output.
+	if r := l.redactor.Load(); r != nil {
+		message = r.String(message)
+		properties = r.Properties(properties)
+	}
+
 	// Declare an anonymous struct holding the data for the log entry.
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string     `json:"level"`
+		Time       string     `json:"time"`
+		Message    string     `json:"message"`
+		Properties Properties `json:"properties,omitempty"`
+		Trace      string     `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
+		Time:       t.UTC().Format(time.RFC3339),
 		Message:    message,
 		Properties: properties,
 	}
 
-	// Include a stack trace for entries at the ERROR and FATAL levels.
-	if level >= LevelError {
+	// Include a stack trace for entries at or above the trace level (by default, the
+	// ERROR and FATAL levels).
+	if level >= Level(l.traceLevel.Load()) {
 		aux.Trace = string(debug.Stack())
 	}
 
@@ -125,3 +235,52 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 func (l *Logger) Write(message []byte) (n int, err error) {
 	return l.print(LevelError, string(message), nil)
 }
+
+// Flush blocks until entries buffered by the output (such as an AsyncWriter's queue)
+// have been written. It does nothing for outputs which don't buffer.
+func (l *Logger) Flush() error {
+	if f, ok := l.out.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close flushes and closes the output, if it's an io.Closer other than stdout or
+// stderr. The logger shouldn't be used afterwards. It's called by the API server as the
+// last step of a graceful shutdown.
+func (l *Logger) Close() error {
+	if err := l.Flush(); err != nil {
+		return err
+	}
+	return closeWriter(l.out)
+}
+
+// mergeProperties returns a new map holding the properties from base overlaid with
+// those from extra, with values converted by propertyValue(). It returns nil if both
+// are empty, so that entries without properties omit the field.
+func mergeProperties(base, extra Properties) Properties {
+	if len(base)+len(extra) == 0 {
+		return nil
+	}
+
+	merged := make(Properties, len(base)+len(extra))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = propertyValue(v)
+	}
+	return merged
+}
+
+// propertyValue converts the values which encoding/json doesn't render usefully.
+func propertyValue(v any) any {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.String()
+	case error:
+		return v.Error()
+	default:
+		return v
+	}
+}
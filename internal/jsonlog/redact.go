@@ -0,0 +1,234 @@
+package jsonlog
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the values which a Redactor masks.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactKeys are the property keys whose values are always masked. Keys are
+// compared case-insensitively, with "-" treated as "_", so "X-Api-Key" matches
+// "x_api_key".
+var DefaultRedactKeys = []string{
+	"password",
+	"password_hash",
+	"token",
+	"access_token",
+	"refresh_token",
+	"secret",
+	"api_key",
+	"x_api_key",
+	"authorization",
+	"cookie",
+	"set_cookie",
+	"email",
+	"dsn",
+}
+
+// sensitiveKeyParts mark a key as sensitive when it ends with one of them, so that keys
+// like "new_password" or "csrf_token" are caught without listing every variation. Only
+// the end of the key is checked: a key like "token_count" or "password_changed_at"
+// names something about the secret, not the secret itself.
+var sensitiveKeyParts = []string{"password", "secret", "token"}
+
+// RedactRule masks the parts of a string value which match Pattern. If Valid is set,
+// a match is only masked when Valid returns true for it, which lets a rule use a loose
+// pattern and then check the match properly.
+type RedactRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Valid   func(match string) bool
+	Replace string
+}
+
+// DefaultRedactRules mask emails, bearer tokens and card-like numbers wherever they
+// appear in a message or a string property.
+var DefaultRedactRules = []RedactRule{
+	{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+		Replace: Redacted,
+	},
+	{
+		Name:    "bearer",
+		Pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+		Replace: "Bearer " + Redacted,
+	},
+	{
+		// 13 to 19 digits, optionally separated by single spaces or dashes, which look
+		// like a card number: see cardNumber().
+		Name:    "card",
+		Pattern: regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`),
+		Valid:   cardNumber,
+		Replace: Redacted,
+	},
+}
+
+// Redactor masks sensitive data in log entries before they're written. Values of
+// properties with a sensitive key are replaced outright, and every string (the message
+// and string property values, including those nested in maps and slices) is checked
+// against the rules. Values of other types, such as structs, are written as they are,
+// so anything which might contain sensitive data should be logged as a string or map.
+//
+// A Redactor is safe for concurrent use, and must not be modified once it's in use.
+type Redactor struct {
+	keys  map[string]bool
+	rules []RedactRule
+}
+
+// NewRedactor returns a Redactor using the default keys and rules, plus the given
+// extra keys.
+func NewRedactor(extraKeys ...string) *Redactor {
+	r := &Redactor{
+		keys:  make(map[string]bool),
+		rules: DefaultRedactRules,
+	}
+
+	for _, key := range DefaultRedactKeys {
+		r.keys[normalizeKey(key)] = true
+	}
+	for _, key := range extraKeys {
+		r.keys[normalizeKey(key)] = true
+	}
+
+	return r
+}
+
+func normalizeKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "-", "_")
+}
+
+// SensitiveKey reports whether values stored under key are masked.
+func (r *Redactor) SensitiveKey(key string) bool {
+	key = normalizeKey(key)
+
+	if r.keys[key] {
+		return true
+	}
+
+	for _, part := range sensitiveKeyParts {
+		if strings.HasSuffix(key, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns s with every match of the rules masked.
+func (r *Redactor) String(s string) string {
+	for _, rule := range r.rules {
+		if rule.Valid == nil {
+			s = rule.Pattern.ReplaceAllString(s, rule.Replace)
+			continue
+		}
+
+		s = rule.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if rule.Valid(match) {
+				return rule.Replace
+			}
+			return match
+		})
+	}
+
+	return s
+}
+
+// Properties returns a copy of p with sensitive values masked. p isn't modified.
+func (r *Redactor) Properties(p Properties) Properties {
+	if p == nil {
+		return nil
+	}
+
+	redacted := make(Properties, len(p))
+	for k, v := range p {
+		if r.SensitiveKey(k) {
+			redacted[k] = Redacted
+			continue
+		}
+		redacted[k] = r.value(v)
+	}
+	return redacted
+}
+
+// value redacts a single property value, descending into maps and slices.
+func (r *Redactor) value(v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.String(v)
+	case map[string]any:
+		return r.Properties(v)
+	case map[string]string:
+		redacted := make(map[string]string, len(v))
+		for k, s := range v {
+			if r.SensitiveKey(k) {
+				redacted[k] = Redacted
+				continue
+			}
+			redacted[k] = r.String(s)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(v))
+		for i, e := range v {
+			redacted[i] = r.value(e)
+		}
+		return redacted
+	case []string:
+		redacted := make([]string, len(v))
+		for i, s := range v {
+			redacted[i] = r.String(s)
+		}
+		return redacted
+	default:
+		return v
+	}
+}
+
+// cardNumber reports whether s could be a payment card number. It must start with a
+// digit used by the card networks (3 to 6, or 2 for the Mastercard 2221-2720 range) and
+// pass the Luhn check. The Luhn check alone lets about one in ten numbers through, and
+// the prefix stops the common false positives: Unix timestamps in milliseconds or
+// nanoseconds start with 1 for years to come, and so do most sequential IDs.
+func cardNumber(s string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+
+	switch digits[0] {
+	case '3', '4', '5', '6':
+	case '2':
+		if prefix := digits[:4]; prefix < "2221" || prefix > "2720" {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return luhnValid(digits)
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum used by payment
+// card numbers. Spaces and dashes are ignored.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+
+	return n > 0 && sum%10 == 0
+}
@@ -0,0 +1,169 @@
+package jsonlog
+
+import "testing"
+
+func TestRedactorString(t *testing.T) {
+	r := NewRedactor()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		// Emails.
+		{"email", "sent to alice@example.com", "sent to [REDACTED]"},
+		{"email with plus and subdomain", "bob+films@mail.example.co.uk bounced", "[REDACTED] bounced"},
+		{"not an email", "user@localhost", "user@localhost"},
+
+		// Bearer tokens.
+		{"bearer", "Authorization: Bearer abc.def-123_~+/==", "Authorization: Bearer [REDACTED]"},
+		{"bearer lower case", "bearer XYZ123 expired", "Bearer [REDACTED] expired"},
+
+		// Card numbers.
+		{"visa", "card 4111111111111111 declined", "card [REDACTED] declined"},
+		{"visa with spaces", "4111 1111 1111 1111", "[REDACTED]"},
+		{"visa with dashes", "4111-1111-1111-1111", "[REDACTED]"},
+		{"mastercard", "5500005555555559", "[REDACTED]"},
+		{"mastercard 2-series", "2223003122003222", "[REDACTED]"},
+		{"amex", "378282246310005", "[REDACTED]"},
+		{"discover", "6011111111111117", "[REDACTED]"},
+		{"failed luhn check", "4111111111111112", "4111111111111112"},
+
+		// Numbers of card length which aren't card numbers. Each of these passes the
+		// Luhn check, so only the prefix check stops them being masked.
+		{"timestamp in milliseconds", "at 1729338645123", "at 1729338645123"},
+		{"timestamp in nanoseconds", "at 1729338645123456780", "at 1729338645123456780"},
+		{"sequential ID", "movie 1000000000000123", "movie 1000000000000123"},
+		{"ID starting with 9", "9000000000000019", "9000000000000019"},
+		{"2-series outside the Mastercard range", "2000000000000008", "2000000000000008"},
+
+		// Numbers of the wrong length or shape.
+		{"12 digits", "411111111111", "411111111111"},
+		{"20 digits", "41111111111111111111", "41111111111111111111"},
+		{"ISO timestamp", "2024-10-19 12:30:45.123456", "2024-10-19 12:30:45.123456"},
+		{"compact timestamp", "20241019123045123", "20241019123045123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.String(tt.input); got != tt.want {
+				t.Errorf("String(%q) = %q; want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactorSensitiveKey(t *testing.T) {
+	r := NewRedactor("session_id")
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"password", true},
+		{"Password", true},
+		{"password_hash", true},
+		{"X-Api-Key", true},
+		{"authorization", true},
+		{"email", true},
+		{"dsn", true},
+		{"session_id", true},
+		{"Session-ID", true},
+
+		// Caught by the suffix rule.
+		{"new_password", true},
+		{"csrf_token", true},
+		{"client_secret", true},
+		{"accessToken", true},
+
+		// Keys which mention a secret without holding one.
+		{"token_count", false},
+		{"password_changed_at", false},
+		{"secret_rotation_days", false},
+		{"tokens_used", false},
+
+		{"title", false},
+		{"user_id", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := r.SensitiveKey(tt.key); got != tt.want {
+				t.Errorf("SensitiveKey(%q) = %t; want %t", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"4111-1111-1111-1111", true},
+		{"378282246310005", true},
+		{"1729338645123", true},
+		{"0", true},
+		{"4111111111111112", false},
+		{"1234567890123456", false},
+		{"20241019123045123", false},
+		{"", false},
+		{" - ", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := luhnValid(tt.input); got != tt.want {
+				t.Errorf("luhnValid(%q) = %t; want %t", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactorProperties(t *testing.T) {
+	r := NewRedactor()
+
+	props := Properties{
+		"password": "hunter2",
+		"note":     "contact alice@example.com",
+		"count":    3,
+		"nested":   map[string]any{"api_key": "k", "card": "4111111111111111"},
+		"headers":  map[string]string{"Authorization": "Bearer abc", "Accept": "*/*"},
+		"list":     []string{"bob@example.com", "ok"},
+	}
+
+	got := r.Properties(props)
+
+	if got["password"] != Redacted {
+		t.Errorf("password = %v; want %q", got["password"], Redacted)
+	}
+	if got["note"] != "contact "+Redacted {
+		t.Errorf("note = %v", got["note"])
+	}
+	if got["count"] != 3 {
+		t.Errorf("count = %v; want 3", got["count"])
+	}
+
+	nested := got["nested"].(Properties)
+	if nested["api_key"] != Redacted || nested["card"] != Redacted {
+		t.Errorf("nested = %v", nested)
+	}
+
+	headers := got["headers"].(map[string]string)
+	if headers["Authorization"] != Redacted || headers["Accept"] != "*/*" {
+		t.Errorf("headers = %v", headers)
+	}
+
+	list := got["list"].([]string)
+	if list[0] != Redacted || list[1] != "ok" {
+		t.Errorf("list = %v", list)
+	}
+
+	// The original properties must be left alone.
+	if props["password"] != "hunter2" {
+		t.Errorf("Properties() modified its argument")
+	}
+}
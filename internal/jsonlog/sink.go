@@ -0,0 +1,438 @@
+package jsonlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flusher is implemented by outputs which buffer entries, so that Logger.Flush() can
+// make sure they've been written.
+type flusher interface {
+	Flush() error
+}
+
+// Multi returns a writer which writes every entry to all of the given writers. Unlike
+// io.MultiWriter, a failing writer doesn't stop the entry reaching the others; the
+// first error is returned once they've all been tried. Flush() and Close() are passed
+// through to the writers which support them.
+func Multi(writers ...io.Writer) io.WriteCloser {
+	return &multiWriter{writers: writers}
+}
+
+type multiWriter struct {
+	writers []io.Writer
+}
+
+func (m *multiWriter) Write(p []byte) (int, error) {
+	var firstErr error
+	for _, w := range m.writers {
+		if _, err := w.Write(p); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return len(p), nil
+}
+
+func (m *multiWriter) Flush() error {
+	var firstErr error
+	for _, w := range m.writers {
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (m *multiWriter) Close() error {
+	var firstErr error
+	for _, w := range m.writers {
+		if err := closeWriter(w); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// closeWriter closes w if it's an io.Closer, other than the standard output and error
+// streams, which are left open for whatever else writes to them.
+func closeWriter(w io.Writer) error {
+	if w == io.Writer(os.Stdout) || w == io.Writer(os.Stderr) {
+		return nil
+	}
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// RotateOptions control when a RotatingFile is rotated and how many old files are kept.
+// A zero value for any option disables that behaviour.
+type RotateOptions struct {
+	MaxSize    int64         // Rotate once the file reaches this many bytes.
+	Interval   time.Duration // Rotate once the file has been open this long.
+	MaxBackups int           // Keep at most this many rotated files.
+	MaxAge     time.Duration // Delete rotated files older than this.
+	Compress   bool          // Gzip rotated files.
+}
+
+// RotatingFile is a log file which is rotated by size and/or age. Rotated files are
+// renamed with a timestamp (api.log becomes api-20240102T150405.000.log), optionally
+// gzipped, and pruned according to the retention options. Compression and pruning
+// happen in the background, so they don't hold up the entry that triggered rotation.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	// cleanup is signalled after each rotation; the background goroutine compresses
+	// and prunes the rotated files.
+	cleanup chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewRotatingFile opens (or creates) the log file at path, appending to it.
+func NewRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:    path,
+		opts:    opts,
+		cleanup: make(chan struct{}, 1),
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	f.wg.Add(1)
+	go f.runCleanup()
+
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	err := os.MkdirAll(filepath.Dir(f.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it over the size
+// limit or the rotation interval has passed.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	var rotateErr error
+	if f.shouldRotate(int64(len(p))) {
+		rotateErr = f.rotate()
+		if f.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	// If the rotation failed but the current file could be reopened, the entry is still
+	// written to it rather than lost, and the rotation is retried on the next write.
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (f *RotatingFile) shouldRotate(next int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+next > f.opts.MaxSize {
+		return true
+	}
+	if f.opts.Interval > 0 && time.Since(f.openedAt) >= f.opts.Interval {
+		return true
+	}
+	return false
+}
+
+// rotate closes the current file, renames it with a timestamp and opens a new one. If
+// any step fails, the file at the original path is reopened for appending, so that a
+// failed rotation (a full disk, say, or a permissions problem) doesn't stop logging
+// altogether.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return errors.Join(err, f.open())
+	}
+
+	err = os.Rename(f.path, f.backupName(time.Now()))
+	if err != nil {
+		return errors.Join(err, f.open())
+	}
+
+	err = f.open()
+	if err != nil {
+		return errors.Join(err, f.open())
+	}
+
+	select {
+	case f.cleanup <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// backupTimeFormat is the layout of the timestamp in the names of rotated files.
+const backupTimeFormat = "20060102T150405.000"
+
+// backupName returns the name for a file rotated at t.
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext)
+	return base + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// backups returns the rotated files, oldest first. The timestamp in the names sorts
+// in time order, so a string sort is enough.
+//
+// Only names in exactly the form backupName() writes, with or without a ".gz" suffix,
+// are counted. Other files which happen to share the prefix, such as api-access.log
+// next to api.log, belong to someone else and must never be pruned.
+func (f *RotatingFile) backups() ([]string, error) {
+	dir := filepath.Dir(f.path)
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(filepath.Base(f.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		rest, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok {
+			continue
+		}
+		stamp, ok := strings.CutSuffix(strings.TrimSuffix(rest, ".gz"), ext)
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+
+		matches = append(matches, filepath.Join(dir, entry.Name()))
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (f *RotatingFile) runCleanup() {
+	defer f.wg.Done()
+
+	for range f.cleanup {
+		f.compressAndPrune()
+	}
+}
+
+// compressAndPrune gzips any uncompressed rotated files (if enabled) and deletes the
+// rotated files which fall outside the retention options. Errors are ignored: there's
+// nowhere sensible to log them, and the next rotation will try again.
+func (f *RotatingFile) compressAndPrune() {
+	backups, err := f.backups()
+	if err != nil {
+		return
+	}
+
+	if f.opts.Compress {
+		for i, name := range backups {
+			if strings.HasSuffix(name, ".gz") {
+				continue
+			}
+			if err := gzipFile(name); err == nil {
+				backups[i] = name + ".gz"
+			}
+		}
+	}
+
+	for i, name := range backups {
+		remove := f.opts.MaxBackups > 0 && i < len(backups)-f.opts.MaxBackups
+
+		if !remove && f.opts.MaxAge > 0 {
+			if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > f.opts.MaxAge {
+				remove = true
+			}
+		}
+
+		if remove {
+			os.Remove(name)
+		}
+	}
+}
+
+// gzipFile compresses name to name.gz and removes the original.
+func gzipFile(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(name + ".gz")
+		}
+	}()
+
+	gz := gzip.NewWriter(dst)
+
+	_, err = io.Copy(gz, src)
+	if err != nil {
+		return err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// Flush commits the file's contents to stable storage.
+func (f *RotatingFile) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close closes the file and waits for any background compression to finish.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	if f.file == nil {
+		f.mu.Unlock()
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	close(f.cleanup)
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return err
+}
+
+// SocketWriter sends each entry as a single message to a local socket, such as a
+// syslog daemon or log shipper listening on a unix datagram socket. If a write fails
+// the connection is re-established and the write retried once, so that a restart of
+// the listener doesn't silently stop the logging.
+type SocketWriter struct {
+	network, addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialSocket connects to the socket. The network is one of "unixgram", "unix", "udp"
+// or "tcp", as accepted by net.Dial.
+func DialSocket(network, addr string) (*SocketWriter, error) {
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SocketWriter{network: network, addr: addr, conn: conn}, nil
+}
+
+func (s *SocketWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		n, err := s.conn.Write(p)
+		if err == nil {
+			return n, nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	s.conn = conn
+
+	return s.conn.Write(p)
+}
+
+// Close closes the connection.
+func (s *SocketWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,476 @@
+package jsonlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listFiles returns the names of the files in dir, sorted.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// touch creates the file at path with the given modification time.
+func touch(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte("old\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.log")
+
+	f, err := NewRotatingFile(path, RotateOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := f.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+		// Backups are named to the millisecond, so keep rotations apart.
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got backups %v; want 2", backups)
+	}
+
+	// Oldest first, each holding the entries written before it was rotated.
+	for i, want := range []string{"first\n", "second\n"} {
+		got, err := os.ReadFile(backups[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("backup %d: got %q; want %q", i, got, want)
+		}
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "third\n" {
+		t.Errorf("current file: got %q; want %q", got, "third\n")
+	}
+}
+
+func TestRotatingFileDoesNotRotateEmptyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.log")
+
+	f, err := NewRotatingFile(path, RotateOptions{MaxSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// An entry larger than the limit still has to go somewhere, and an empty file
+	// isn't worth rotating.
+	_, err = f.Write([]byte("a long entry\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, []string{"api.log"}) {
+		t.Errorf("got files %v; want only api.log", got)
+	}
+}
+
+func TestRotatingFileCompresses(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.log")
+
+	f, err := NewRotatingFile(path, RotateOptions{MaxSize: 10, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("first\n"))
+	f.Write([]byte("second\n"))
+
+	// Close waits for the background compression to finish.
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".log.gz") {
+		t.Fatalf("got backups %v; want one .log.gz file", backups)
+	}
+
+	file, err := os.Open(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "first\n" {
+		t.Errorf("got %q; want %q", got, "first\n")
+	}
+}
+
+func TestRotatingFileBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.log")
+
+	f := &RotatingFile{path: path}
+
+	now := time.Now()
+	for _, name := range []string{
+		"api.log",
+		"api-20240102T150405.000.log",
+		"api-20240101T000000.000.log.gz",
+		"api-access.log",
+		"api-old.log",
+		"api-2024.log",
+		"api-20240102T150405.log",
+		"api-20240102T150405.000.txt",
+		"web-20240102T150405.000.log",
+	} {
+		touch(t, filepath.Join(dir, name), now)
+	}
+
+	got, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "api-20240101T000000.000.log.gz"),
+		filepath.Join(dir, "api-20240102T150405.000.log"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestRotatingFilePrune(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		opts RotateOptions
+		want []string
+	}{
+		{
+			name: "no limits",
+			want: []string{
+				"api-20240101T000000.000.log.gz",
+				"api-20240102T000000.000.log",
+				"api-20240103T000000.000.log",
+				"api-access.log",
+				"api.log",
+			},
+		},
+		{
+			name: "max backups",
+			opts: RotateOptions{MaxBackups: 1},
+			want: []string{
+				"api-20240103T000000.000.log",
+				"api-access.log",
+				"api.log",
+			},
+		},
+		{
+			name: "max age",
+			opts: RotateOptions{MaxAge: 36 * time.Hour},
+			want: []string{
+				"api-20240102T000000.000.log",
+				"api-20240103T000000.000.log",
+				"api-access.log",
+				"api.log",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// The ages come from the modification times, not the names. The unrelated
+			// api-access.log is the oldest file of all, but must survive every limit.
+			touch(t, filepath.Join(dir, "api.log"), now)
+			touch(t, filepath.Join(dir, "api-access.log"), now.Add(-100*time.Hour))
+			touch(t, filepath.Join(dir, "api-20240101T000000.000.log.gz"), now.Add(-72*time.Hour))
+			touch(t, filepath.Join(dir, "api-20240102T000000.000.log"), now.Add(-24*time.Hour))
+			touch(t, filepath.Join(dir, "api-20240103T000000.000.log"), now.Add(-time.Hour))
+
+			f := &RotatingFile{path: filepath.Join(dir, "api.log"), opts: tt.opts}
+			f.compressAndPrune()
+
+			if got := listFiles(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got files %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotatingFileWriteAfterClose(t *testing.T) {
+	f, err := NewRotatingFile(filepath.Join(t.TempDir(), "api.log"), RotateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	_, err = f.Write([]byte("late\n"))
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("got error %v; want os.ErrClosed", err)
+	}
+}
+
+// gatedWriter is an output whose writes block until the gate is opened, so that tests
+// can fill an AsyncWriter's queue. Each write is announced on started.
+type gatedWriter struct {
+	gate    chan struct{}
+	started chan struct{}
+
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{gate: make(chan struct{}), started: make(chan struct{}, 100)}
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.gate
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gatedWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *gatedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestAsyncWriterDropsWhenFull(t *testing.T) {
+	out := newGatedWriter()
+	a := NewAsyncWriter(out, 2)
+
+	// The first entry is taken off the queue and blocks in the output, then two more
+	// fill the queue. Anything after that is dropped.
+	a.Write([]byte("1\n"))
+	<-out.started
+
+	for _, entry := range []string{"2\n", "3\n", "4\n", "5\n"} {
+		n, err := a.Write([]byte(entry))
+		if err != nil || n != len(entry) {
+			t.Fatalf("got %d, %v; want %d, nil", n, err, len(entry))
+		}
+	}
+
+	if got := a.Dropped(); got != 2 {
+		t.Errorf("got %d dropped; want 2", got)
+	}
+
+	close(out.gate)
+
+	err := a.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := out.String(); got != "1\n2\n3\n" {
+		t.Errorf("got output %q; want %q", got, "1\n2\n3\n")
+	}
+	if got := a.Written(); got != 3 {
+		t.Errorf("got %d written; want 3", got)
+	}
+
+	a.Close()
+}
+
+func TestAsyncWriterFlushAndClose(t *testing.T) {
+	out := newGatedWriter()
+	close(out.gate)
+
+	a := NewAsyncWriter(out, 100)
+
+	// The caller may reuse its buffer as soon as Write returns.
+	p := []byte("a\n")
+	a.Write(p)
+	copy(p, "b\n")
+	a.Write(p)
+
+	err := a.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "a\nb\n" {
+		t.Errorf("after Flush: got %q; want %q", got, "a\nb\n")
+	}
+
+	a.Write([]byte("c\n"))
+
+	err = a.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "a\nb\nc\n" {
+		t.Errorf("after Close: got %q; want %q", got, "a\nb\nc\n")
+	}
+	if !out.closed {
+		t.Error("output wasn't closed")
+	}
+
+	_, err = a.Write([]byte("d\n"))
+	if !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("got error %v after Close; want ErrQueueClosed", err)
+	}
+
+	// Flush and Close after Close are harmless.
+	if err := a.Flush(); err != nil {
+		t.Error(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAsyncWriterCountsFailedWrites(t *testing.T) {
+	a := NewAsyncWriter(failingWriter{}, 10)
+
+	a.Write([]byte("x\n"))
+	a.Flush()
+
+	if a.Written() != 0 || a.Dropped() != 1 {
+		t.Errorf("got %d written, %d dropped; want 0, 1", a.Written(), a.Dropped())
+	}
+
+	a.Close()
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestSocketWriterReconnects(t *testing.T) {
+	// Unix socket paths are limited to around 100 bytes, which a t.TempDir() path can
+	// exceed, so use a short directory of our own.
+	dir, err := os.MkdirTemp("", "jsonlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	addr := filepath.Join(dir, "log.sock")
+
+	listen := func() net.PacketConn {
+		t.Helper()
+		os.Remove(addr)
+		conn, err := net.ListenPacket("unixgram", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	read := func(conn net.PacketConn) string {
+		t.Helper()
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	listener := listen()
+
+	s, err := DialSocket("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	_, err = s.Write([]byte("first\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(listener); got != "first\n" {
+		t.Errorf("got %q; want %q", got, "first\n")
+	}
+
+	// Restart the listener, as a log daemon restarting would. The next write fails on
+	// the old connection, so the writer redials and sends it again.
+	listener.Close()
+	listener = listen()
+	defer listener.Close()
+
+	_, err = s.Write([]byte("second\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(listener); got != "second\n" {
+		t.Errorf("got %q; want %q", got, "second\n")
+	}
+}
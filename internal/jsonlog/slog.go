@@ -0,0 +1,151 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SlogHandler adapts a Logger to the slog.Handler interface, so that the standard
+// library's log/slog (and any package which logs through it) writes jsonlog entries.
+// Record attributes become properties, and slog groups become nested objects.
+type SlogHandler struct {
+	logger *Logger
+	attrs  Properties
+	groups []string
+}
+
+// NewSlogHandler returns a slog.Handler which writes to the logger.
+func NewSlogHandler(logger *Logger) *SlogHandler {
+	return &SlogHandler{logger: logger}
+}
+
+// slogLevel maps a slog level onto the nearest jsonlog level. slog levels are spaced
+// four apart, with custom levels in between, so each jsonlog level covers a range.
+func slogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+// Enabled reports whether the logger would write an entry at the given level.
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(slogLevel(level))
+}
+
+// Handle writes the record as a jsonlog entry. The entry is stamped with the record's
+// time, or with the current time if the record doesn't have one, as the slog.Handler
+// rules require.
+func (h *SlogHandler) Handle(_ context.Context, record slog.Record) error {
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	t := record.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	_, err := h.logger.printAt(t, slogLevel(record.Level), record.Message, h.addAttrs(attrs))
+	return err
+}
+
+// WithAttrs returns a handler which adds the attributes to every entry, inside any
+// groups opened so far.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return &SlogHandler{logger: h.logger, attrs: h.addAttrs(attrs), groups: h.groups}
+}
+
+// WithGroup returns a handler which nests the attributes added after it under name.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	groups := make([]string, len(h.groups), len(h.groups)+1)
+	copy(groups, h.groups)
+
+	return &SlogHandler{logger: h.logger, attrs: h.attrs, groups: append(groups, name)}
+}
+
+// addAttrs returns a copy of the handler's properties with the attributes added under
+// the open groups. Only the maps along the group path are copied; the handler's own
+// properties are never modified, since other handlers derived from it share them.
+func (h *SlogHandler) addAttrs(attrs []slog.Attr) Properties {
+	root := copyProperties(h.attrs)
+
+	target := root
+	for _, group := range h.groups {
+		nested, _ := target[group].(Properties)
+		nested = copyProperties(nested)
+		target[group] = nested
+		target = nested
+	}
+
+	for _, a := range attrs {
+		addAttr(target, a)
+	}
+
+	return root
+}
+
+func copyProperties(p Properties) Properties {
+	c := make(Properties, len(p))
+	for k, v := range p {
+		c[k] = v
+	}
+	return c
+}
+
+// addAttr adds a single attribute to dst, following the slog.Handler rules: empty
+// attributes are ignored, and groups with an empty key are inlined.
+func addAttr(dst Properties, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		group := a.Value.Group()
+		if len(group) == 0 {
+			return
+		}
+
+		target := dst
+		if a.Key != "" {
+			target = Properties{}
+			dst[a.Key] = target
+		}
+		for _, ga := range group {
+			addAttr(target, ga)
+		}
+		return
+	}
+
+	dst[a.Key] = slogValue(a.Value)
+}
+
+// slogValue converts a resolved, non-group slog.Value to a value for the properties.
+func slogValue(v slog.Value) any {
+	switch v.Kind() {
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	default:
+		return propertyValue(v.Any())
+	}
+}
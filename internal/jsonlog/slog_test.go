@@ -0,0 +1,268 @@
+package jsonlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slogEntry is a decoded jsonlog entry written through a SlogHandler.
+type slogEntry struct {
+	Level      string         `json:"level"`
+	Time       string         `json:"time"`
+	Message    string         `json:"message"`
+	Properties map[string]any `json:"properties"`
+}
+
+// newSlogTest returns a slog.Logger which writes through a SlogHandler to logger, and a
+// function which decodes the entries written to buf (the logger's output) so far.
+func newSlogTest(t *testing.T, logger *Logger, buf *bytes.Buffer) (*slog.Logger, func() []slogEntry) {
+	t.Helper()
+
+	entries := func() []slogEntry {
+		t.Helper()
+
+		var got []slogEntry
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var e slogEntry
+			err := json.Unmarshal([]byte(line), &e)
+			if err != nil {
+				t.Fatalf("bad entry %q: %v", line, err)
+			}
+			got = append(got, e)
+		}
+		return got
+	}
+
+	return slog.New(NewSlogHandler(logger)), entries
+}
+
+func TestSlogHandlerLevels(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  Level
+	}{
+		{slog.LevelDebug - 4, LevelDebug},
+		{slog.LevelDebug, LevelDebug},
+		{slog.LevelInfo, LevelInfo},
+		{slog.LevelInfo + 2, LevelInfo},
+		{slog.LevelWarn, LevelWarn},
+		{slog.LevelError, LevelError},
+		{slog.LevelError + 4, LevelError},
+	}
+
+	for _, tt := range tests {
+		if got := slogLevel(tt.level); got != tt.want {
+			t.Errorf("slogLevel(%v): got %v; want %v", tt.level, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	logger := New(&buf, LevelWarn)
+	logger.SetTraceLevel(LevelOff)
+
+	log, entries := newSlogTest(t, logger, &buf)
+
+	if log.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("got INFO enabled on a WARN logger")
+	}
+	if !log.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("got WARN disabled on a WARN logger")
+	}
+
+	log.Debug("debug")
+	log.Info("info")
+	log.Warn("warn")
+	log.Error("error")
+
+	got := entries()
+	if len(got) != 2 {
+		t.Fatalf("got %d entries; want 2", len(got))
+	}
+	if got[0].Level != "WARN" || got[0].Message != "warn" {
+		t.Errorf("got %s %q; want WARN \"warn\"", got[0].Level, got[0].Message)
+	}
+	if got[1].Level != "ERROR" || got[1].Message != "error" {
+		t.Errorf("got %s %q; want ERROR \"error\"", got[1].Level, got[1].Message)
+	}
+}
+
+func TestSlogHandlerTime(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, LevelInfo)
+
+	h := NewSlogHandler(logger)
+
+	// A record made earlier keeps its own time.
+	when := time.Date(2001, 2, 3, 4, 5, 6, 0, time.FixedZone("UTC+7", 7*60*60))
+	err := h.Handle(context.Background(), slog.NewRecord(when, slog.LevelInfo, "then", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A record without a time is stamped when it's handled.
+	before := time.Now().UTC().Truncate(time.Second)
+	err = h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "now", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, entries := newSlogTest(t, logger, &buf)
+	got := entries()
+
+	if len(got) != 2 {
+		t.Fatalf("got %d entries; want 2", len(got))
+	}
+	if got[0].Time != "2001-02-02T21:05:06Z" {
+		t.Errorf("got time %q; want the record's time in UTC", got[0].Time)
+	}
+
+	stamped, err := time.Parse(time.RFC3339, got[1].Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stamped.Before(before) || stamped.After(time.Now()) {
+		t.Errorf("got time %v for a record without one; want the current time", stamped)
+	}
+}
+
+func TestSlogHandlerProperties(t *testing.T) {
+	tests := []struct {
+		name string
+		with Properties // added to the Logger itself, before it's wrapped
+		log  func(log *slog.Logger)
+		want map[string]any
+	}{
+		{
+			name: "attributes",
+			log: func(log *slog.Logger) {
+				log.Info("m", "method", "GET", "status", 200, "duration", 1500*time.Millisecond)
+			},
+			want: map[string]any{"method": "GET", "status": float64(200), "duration": "1.5s"},
+		},
+		{
+			name: "With",
+			with: Properties{"service": "api"},
+			log: func(log *slog.Logger) {
+				log.With("request_id", "abc").Info("m", "status", 200)
+			},
+			want: map[string]any{"service": "api", "request_id": "abc", "status": float64(200)},
+		},
+		{
+			name: "record attributes override the logger's",
+			with: Properties{"service": "api"},
+			log: func(log *slog.Logger) {
+				log.With("service", "worker").Info("m")
+			},
+			want: map[string]any{"service": "worker"},
+		},
+		{
+			name: "WithGroup",
+			with: Properties{"service": "api"},
+			log: func(log *slog.Logger) {
+				log.WithGroup("request").With("id", "abc").Info("m", "status", 200)
+			},
+			want: map[string]any{
+				"service": "api",
+				"request": map[string]any{"id": "abc", "status": float64(200)},
+			},
+		},
+		{
+			name: "nested WithGroup",
+			with: Properties{"service": "api"},
+			log: func(log *slog.Logger) {
+				log.WithGroup("a").With("x", 1).WithGroup("b").Info("m", "y", 2)
+			},
+			want: map[string]any{
+				"service": "api",
+				"a":       map[string]any{"x": float64(1), "b": map[string]any{"y": float64(2)}},
+			},
+		},
+		{
+			name: "group attribute",
+			log: func(log *slog.Logger) {
+				log.Info("m", slog.Group("user", "name", "bob", "admin", true))
+			},
+			want: map[string]any{"user": map[string]any{"name": "bob", "admin": true}},
+		},
+		{
+			name: "group with an empty key is inlined",
+			log: func(log *slog.Logger) {
+				log.Info("m", slog.Group("", "a", 1, "b", 2))
+			},
+			want: map[string]any{"a": float64(1), "b": float64(2)},
+		},
+		{
+			name: "empty group and empty attribute are dropped",
+			log: func(log *slog.Logger) {
+				log.Info("m", slog.Group("nothing"), slog.Attr{}, "kept", "yes")
+			},
+			want: map[string]any{"kept": "yes"},
+		},
+		{
+			name: "empty WithGroup is ignored",
+			log: func(log *slog.Logger) {
+				log.WithGroup("").Info("m", "a", 1)
+			},
+			want: map[string]any{"a": float64(1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := New(&buf, LevelInfo).With(tt.with)
+
+			log, entries := newSlogTest(t, logger, &buf)
+			tt.log(log)
+
+			got := entries()
+			if len(got) != 1 {
+				t.Fatalf("got %d entries; want 1", len(got))
+			}
+			if !reflect.DeepEqual(got[0].Properties, tt.want) {
+				t.Errorf("got properties %v; want %v", got[0].Properties, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlogHandlerDerivedHandlersAreIndependent(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, LevelInfo)
+
+	log, entries := newSlogTest(t, logger, &buf)
+
+	parent := log.WithGroup("g").With("shared", 1)
+	a := parent.With("a", 1)
+	b := parent.With("b", 2)
+
+	a.Info("a")
+	b.Info("b")
+	parent.Info("parent")
+
+	got := entries()
+	if len(got) != 3 {
+		t.Fatalf("got %d entries; want 3", len(got))
+	}
+
+	want := []map[string]any{
+		{"g": map[string]any{"shared": float64(1), "a": float64(1)}},
+		{"g": map[string]any{"shared": float64(1), "b": float64(2)}},
+		{"g": map[string]any{"shared": float64(1)}},
+	}
+
+	for i := range want {
+		if !reflect.DeepEqual(got[i].Properties, want[i]) {
+			t.Errorf("entry %q: got properties %v; want %v", got[i].Message, got[i].Properties, want[i])
+		}
+	}
+}
@@ -0,0 +1,370 @@
+// Package metrics is a small, dependency-free implementation of counters, gauges and
+// histograms which can be exposed in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets (in seconds), matching the defaults
+// used by the official Prometheus client libraries.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is anything which can write its samples in the text exposition format.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics and renders them on request.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	hooks      []func()
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// BeforeScrape registers fn to be called at the start of every scrape, before any
+// metric is written. It lets several function metrics share a value which is expensive
+// to read, by reading it once in fn.
+func (r *Registry) BeforeScrape(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, fn)
+}
+
+// WriteTo writes every registered metric to w in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	hooks := append([]func(){}, r.hooks...)
+	r.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+
+	cw := &countingWriter{w: w}
+	buf := bufio.NewWriter(cw)
+
+	for _, c := range collectors {
+		c.write(buf)
+	}
+
+	err := buf.Flush()
+	return cw.n, err
+}
+
+// Handler returns an http.Handler which serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// vec holds the labelled series of a single metric. The series map is keyed by the
+// label values joined with a separator which can't appear in valid UTF-8.
+type vec[T any] struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+	newT   func() *T
+}
+
+func newVec[T any](name, help, kind string, labels []string, newT func() *T) *vec[T] {
+	return &vec[T]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*T),
+		values: make(map[string][]string),
+		newT:   newT,
+	}
+}
+
+// get returns the series for a set of label values, creating it if needed.
+func (v *vec[T]) get(labelValues []string) *T {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newT()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), labelValues...)
+	}
+
+	return s
+}
+
+// each calls fn for every series in a stable order, with its rendered label pairs.
+func (v *vec[T]) each(fn func(labels []string, s *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	v.mu.Unlock()
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.Lock()
+		s, values := v.series[key], v.values[key]
+		v.mu.Unlock()
+
+		pairs := make([]string, len(values))
+		for i, value := range values {
+			pairs[i] = v.labels[i] + `="` + escapeLabelValue(value) + `"`
+		}
+
+		fn(pairs, s)
+	}
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+}
+
+// value is a single float64 protected by a mutex, used for counter and gauge series.
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(delta float64) {
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// CounterVec is a counter partitioned by a set of labels.
+type CounterVec struct {
+	*vec[value]
+}
+
+// NewCounterVec creates and registers a new CounterVec.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() *value { return &value{} })}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.get(labelValues).add(1)
+}
+
+// Add adds delta (which must not be negative) to the counter for the label values.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.get(labelValues).add(delta)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(labels []string, s *value) {
+		writeSample(w, c.name, labels, s.get())
+	})
+}
+
+// GaugeVec is a gauge partitioned by a set of labels.
+type GaugeVec struct {
+	*vec[value]
+}
+
+// NewGaugeVec creates and registers a new GaugeVec.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() *value { return &value{} })}
+	r.register(g)
+	return g
+}
+
+// Add adds delta (which may be negative) to the gauge for the given label values.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.get(labelValues).add(delta)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(labels []string, s *value) {
+		writeSample(w, g.name, labels, s.get())
+	})
+}
+
+// histogram holds the bucket counts, sum and count of a single histogram series.
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// HistogramVec is a histogram partitioned by a set of labels.
+type HistogramVec struct {
+	*vec[histogram]
+	buckets []float64
+}
+
+// NewHistogramVec creates and registers a new HistogramVec with the given upper
+// bucket bounds. If buckets is nil, DefBuckets is used.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{
+		vec: newVec(name, help, "histogram", labels, func() *histogram {
+			return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		}),
+		buckets: buckets,
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records a single observation for the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	s := h.get(labelValues)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, upper := range s.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(labels []string, s *histogram) {
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, count := s.sum, s.count
+		s.mu.Unlock()
+
+		for i, upper := range s.buckets {
+			le := `le="` + formatFloat(upper) + `"`
+			writeSample(w, h.name+"_bucket", append(labels, le), float64(counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", append(labels, `le="+Inf"`), float64(count))
+		writeSample(w, h.name+"_sum", labels, sum)
+		writeSample(w, h.name+"_count", labels, float64(count))
+	})
+}
+
+// funcMetric is a single unlabelled counter or gauge whose value is read from a
+// function each time the metrics are rendered.
+type funcMetric struct {
+	name, help, kind string
+	fn               func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is provided by fn at scrape time.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers a counter whose value is provided by fn at scrape time.
+// The function must return a value that never decreases.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, kind: "counter", fn: fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	writeSample(w, f.name, nil, f.fn())
+}
+
+func writeSample(w *bufio.Writer, name string, labels []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		w.WriteString(strings.Join(labels, ","))
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// labelValueEscaper escapes a label value as the text format requires. Only the
+// backslash, the double quote and the newline are escaped: everything else, including
+// non-ASCII text, is written as it is, unlike with strconv.Quote().
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written through it, for WriteTo().
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
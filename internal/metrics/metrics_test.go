@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// render returns the registry's metrics in the text exposition format.
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("http_requests_total", "Total HTTP requests.", "method", "status")
+
+	c.Inc("GET", "200")
+	c.Inc("GET", "200")
+	c.Add(2.5, "POST", "201")
+
+	want := `# HELP http_requests_total Total HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",status="200"} 2
+http_requests_total{method="POST",status="201"} 2.5
+`
+
+	if got := render(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterVecNegativeAdd(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("events_total", "Events.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic for a negative Add")
+		}
+	}()
+
+	c.Add(-1)
+}
+
+func TestGaugeAndFuncMetrics(t *testing.T) {
+	r := NewRegistry()
+
+	g := r.NewGaugeVec("in_flight", "Requests in flight.")
+	g.Add(3)
+	g.Add(-1)
+
+	r.NewCounterFunc("uptime_seconds_total", "Uptime.", func() float64 { return 42 })
+
+	want := `# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 2
+# HELP uptime_seconds_total Uptime.
+# TYPE uptime_seconds_total counter
+uptime_seconds_total 42
+`
+
+	if got := render(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBeforeScrape(t *testing.T) {
+	r := NewRegistry()
+
+	var reads, value float64
+	r.BeforeScrape(func() {
+		reads++
+		value = reads * 10
+	})
+	r.NewGaugeFunc("a", "A.", func() float64 { return value })
+	r.NewGaugeFunc("b", "B.", func() float64 { return value + 1 })
+
+	got := render(t, r)
+
+	if reads != 1 {
+		t.Errorf("got %v hook calls for one scrape; want 1", reads)
+	}
+	for _, line := range []string{"a 10\n", "b 11\n"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("output missing %q:\n%s", line, got)
+		}
+	}
+}
+
+func TestLabelValueEscaping(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "plain", value: "GET", want: `GET`},
+		{name: "backslash", value: `C:\path`, want: `C:\\path`},
+		{name: "double quote", value: `say "hi"`, want: `say \"hi\"`},
+		{name: "newline", value: "line1\nline2", want: `line1\nline2`},
+		{name: "non-ASCII", value: "café ☕", want: "café ☕"},
+		{name: "tab", value: "a\tb", want: "a\tb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			c := r.NewCounterVec("c_total", "C.", "v")
+			c.Inc(tt.value)
+
+			want := `c_total{v="` + tt.want + `"} 1` + "\n"
+
+			if got := render(t, r); !strings.HasSuffix(got, want) {
+				t.Errorf("got:\n%s\nwant it to end with:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+
+	// The buckets are deliberately out of order, to check they are sorted. The values
+	// are all exact in binary, so that the sum is too.
+	h := r.NewHistogramVec("duration_seconds", "Request duration.", []float64{1, 0.125, 0.5}, "route")
+
+	for _, v := range []float64{0.0625, 0.125, 0.25, 0.75, 2} {
+		h.Observe(v, "/v1/movies")
+	}
+
+	want := `# HELP duration_seconds Request duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/v1/movies",le="0.125"} 2
+duration_seconds_bucket{route="/v1/movies",le="0.5"} 3
+duration_seconds_bucket{route="/v1/movies",le="1"} 4
+duration_seconds_bucket{route="/v1/movies",le="+Inf"} 5
+duration_seconds_sum{route="/v1/movies"} 3.1875
+duration_seconds_count{route="/v1/movies"} 5
+`
+
+	if got := render(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("h", "H.", nil)
+
+	for _, v := range []float64{0.001, 0.02, 0.02, 0.3, 4, 100} {
+		h.Observe(v)
+	}
+
+	var last float64 = -1
+	var buckets int
+
+	for _, line := range strings.Split(render(t, r), "\n") {
+		if !strings.HasPrefix(line, "h_bucket{") {
+			continue
+		}
+		buckets++
+
+		count, err := strconv.ParseFloat(line[strings.LastIndexByte(line, ' ')+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample line %q: %v", line, err)
+		}
+		if count < last {
+			t.Errorf("bucket count %v after %v; want counts that never decrease", count, last)
+		}
+		last = count
+	}
+
+	if buckets != len(DefBuckets)+1 {
+		t.Errorf("got %d buckets; want %d", buckets, len(DefBuckets)+1)
+	}
+	if last != 6 {
+		t.Errorf("got +Inf bucket %v; want 6", last)
+	}
+}
+
+func TestVecLabelCountMismatch(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("c_total", "C.", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic for the wrong number of label values")
+		}
+	}()
+
+	c.Inc("only-one")
+}
@@ -0,0 +1,288 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NoopExporter discards every span. It's used when tracing is disabled, so that the
+// rest of the application doesn't need to check whether a tracer exists.
+type NoopExporter struct{}
+
+func (NoopExporter) ExportSpan(*SpanData)           {}
+func (NoopExporter) Shutdown(context.Context) error { return nil }
+
+// JSONExporter writes each finished span as a single line of JSON to an io.Writer. It's
+// intended for local development, where the spans can be read alongside the logs.
+type JSONExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewJSONExporter returns a JSONExporter which writes to out.
+func NewJSONExporter(out io.Writer) *JSONExporter {
+	return &JSONExporter{out: out}
+}
+
+// ExportSpan writes the span to the output.
+func (e *JSONExporter) ExportSpan(span *SpanData) {
+	aux := struct {
+		Type       string            `json:"type"`
+		TraceID    string            `json:"trace_id"`
+		SpanID     string            `json:"span_id"`
+		ParentID   string            `json:"parent_id,omitempty"`
+		Name       string            `json:"name"`
+		Kind       string            `json:"kind"`
+		Start      string            `json:"start"`
+		DurationMS float64           `json:"duration_ms"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Error      string            `json:"error,omitempty"`
+	}{
+		Type:       "span",
+		TraceID:    span.TraceID.String(),
+		SpanID:     span.SpanID.String(),
+		Name:       span.Name,
+		Kind:       span.Kind.String(),
+		Start:      span.Start.UTC().Format(time.RFC3339Nano),
+		DurationMS: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+		Attributes: span.Attributes,
+		Error:      span.Error,
+	}
+	if span.ParentID.IsValid() {
+		aux.ParentID = span.ParentID.String()
+	}
+
+	line, err := json.Marshal(aux)
+	if err != nil {
+		return
+	}
+
+	// Write the whole line in one call, so that spans aren't interleaved with other
+	// output sharing the same writer.
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.out.Write(append(line, '\n'))
+}
+
+// Shutdown does nothing, since every span has already been written.
+func (e *JSONExporter) Shutdown(context.Context) error { return nil }
+
+// OTLPExporter sends spans to an OpenTelemetry collector using the OTLP/HTTP protocol
+// with JSON encoding. Spans are queued and sent in batches from a background goroutine;
+// if the queue is full, new spans are dropped rather than blocking requests.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+
+	queue chan *SpanData
+	flush chan chan struct{}
+	once  sync.Once
+
+	// OnError, if set, is called when a batch fails to send.
+	OnError func(error)
+}
+
+const (
+	otlpQueueSize     = 2048
+	otlpBatchSize     = 256
+	otlpFlushInterval = 5 * time.Second
+)
+
+// NewOTLPExporter starts an exporter which posts spans to endpoint (for example
+// "http://localhost:4318/v1/traces"), reporting them as coming from serviceName.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *SpanData, otlpQueueSize),
+		flush:       make(chan chan struct{}),
+	}
+
+	go e.run()
+
+	return e
+}
+
+// ExportSpan queues the span for sending.
+func (e *OTLPExporter) ExportSpan(span *SpanData) {
+	select {
+	case e.queue <- span:
+	default:
+	}
+}
+
+// Shutdown sends any queued spans and stops the background goroutine.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	var err error
+
+	e.once.Do(func() {
+		ack := make(chan struct{})
+
+		select {
+		case e.flush <- ack:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+
+		select {
+		case <-ack:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+
+	return err
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, otlpBatchSize)
+
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil && e.OnError != nil {
+			e.OnError(err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= otlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-e.flush:
+			// Drain whatever is still queued, send it, then stop.
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+					if len(batch) >= otlpBatchSize {
+						send()
+					}
+					continue
+				default:
+				}
+				break
+			}
+			send()
+			close(ack)
+			return
+		}
+	}
+}
+
+// The following types mirror the parts of the OTLP JSON encoding that we use. See
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, len(keys))
+	for i, k := range keys {
+		kvs[i].Key = k
+		kvs[i].Value.StringValue = attrs[k]
+	}
+	return kvs
+}
+
+func (e *OTLPExporter) send(batch []*SpanData) error {
+	spans := make([]otlpSpan, len(batch))
+
+	for i, span := range batch {
+		s := &spans[i]
+		s.TraceID = span.TraceID.String()
+		s.SpanID = span.SpanID.String()
+		if span.ParentID.IsValid() {
+			s.ParentSpanID = span.ParentID.String()
+		}
+		s.Name = span.Name
+		// OTLP span kinds are offset by one from ours (0 is "unspecified").
+		s.Kind = int(span.Kind) + 1
+		s.StartTimeUnixNano = strconv.FormatInt(span.Start.UnixNano(), 10)
+		s.EndTimeUnixNano = strconv.FormatInt(span.End.UnixNano(), 10)
+		s.Attributes = otlpAttributes(span.Attributes)
+		if span.Error != "" {
+			s.Status.Code = 2 // STATUS_CODE_ERROR
+			s.Status.Message = span.Error
+		}
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": e.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/Ramdoni007/21Cinema/internal/trace"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp exporter: unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testSpanData() *SpanData {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	return &SpanData{
+		TraceID:    TraceID{15: 1},
+		SpanID:     SpanID{7: 2},
+		ParentID:   SpanID{7: 3},
+		Name:       "GET /v1/movies/:id",
+		Kind:       KindServer,
+		Start:      start,
+		End:        start.Add(1500 * time.Microsecond),
+		Attributes: map[string]string{"http.status_code": "500"},
+		Error:      "boom",
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	NewJSONExporter(&buf).ExportSpan(testSpanData())
+
+	line := buf.Bytes()
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		t.Fatalf("got %q; want a single line", line)
+	}
+
+	var got map[string]any
+	err := json.Unmarshal(line, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"type":        "span",
+		"trace_id":    "00000000000000000000000000000001",
+		"span_id":     "0000000000000002",
+		"parent_id":   "0000000000000003",
+		"name":        "GET /v1/movies/:id",
+		"kind":        "server",
+		"start":       "2024-01-02T03:04:05Z",
+		"duration_ms": 1.5,
+		"error":       "boom",
+	}
+
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got %s %v; want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies [][]byte
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	e := NewOTLPExporter(ts.URL, "cinema-test")
+	e.OnError = func(err error) { t.Error(err) }
+
+	e.ExportSpan(testSpanData())
+
+	// Shutdown must send the queued span rather than waiting for the flush interval.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := e.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(bodies) != 1 {
+		t.Fatalf("got %d requests; want 1", len(bodies))
+	}
+
+	var payload struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+
+	err = json.Unmarshal(bodies[0], &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(payload.ResourceSpans) != 1 || len(payload.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("got payload %s", bodies[0])
+	}
+
+	attrs := payload.ResourceSpans[0].Resource.Attributes
+	if len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue != "cinema-test" {
+		t.Errorf("got resource attributes %+v", attrs)
+	}
+
+	spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans; want 1", len(spans))
+	}
+
+	s := spans[0]
+	switch {
+	case s.TraceID != "00000000000000000000000000000001":
+		t.Errorf("got trace id %q", s.TraceID)
+	case s.ParentSpanID != "0000000000000003":
+		t.Errorf("got parent span id %q", s.ParentSpanID)
+	case s.Kind != 2:
+		t.Errorf("got kind %d; want 2 (server)", s.Kind)
+	case s.StartTimeUnixNano != "1704164645000000000":
+		t.Errorf("got start %q", s.StartTimeUnixNano)
+	case s.Status.Code != 2 || s.Status.Message != "boom":
+		t.Errorf("got status %+v; want an error status", s.Status)
+	}
+}
+
+func TestOTLPExporterReportsErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+
+	e := NewOTLPExporter(ts.URL, "cinema-test")
+	e.OnError = func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	e.ExportSpan(testSpanData())
+
+	err := e.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(errs) != 1 {
+		t.Errorf("got %d errors; want 1", len(errs))
+	}
+}
@@ -0,0 +1,307 @@
+// Package trace is a minimal distributed tracing implementation. Spans follow the W3C
+// Trace Context model (https://www.w3.org/TR/trace-context/), so trace ids can be
+// propagated to and from other services in the traceparent header, and finished spans
+// are handed to a pluggable Exporter.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a whole trace, across every service it passes through.
+type TraceID [16]byte
+
+// String returns the trace id as 32 lowercase hex characters.
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the id is non-zero, as required by the specification.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// SpanID identifies a single span within a trace.
+type SpanID [8]byte
+
+// String returns the span id as 16 lowercase hex characters.
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the id is non-zero, as required by the specification.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanContext is the part of a span which is propagated between services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both the trace and span ids are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a traceparent header value. It returns false if the value is
+// missing or malformed, in which case the caller should start a new trace.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, false
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	// Version ff is forbidden, and version 00 must have exactly four fields. Future
+	// versions may add fields, which we ignore.
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+
+	if len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceID)); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(spanID)); err != nil {
+		return SpanContext{}, false
+	}
+
+	var f [1]byte
+	if _, err := hex.Decode(f[:], []byte(flags)); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = f[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+
+	return sc, true
+}
+
+// Kind describes the relationship between a span and its callers or callees.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindServer
+	KindClient
+)
+
+// String returns the name of the kind.
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// SpanData is the immutable record of a finished span, as passed to an Exporter.
+type SpanData struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	ParentID   SpanID
+	Name       string
+	Kind       Kind
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string
+}
+
+// Exporter receives finished spans. ExportSpan is called synchronously when a span
+// ends, so implementations which do I/O should buffer internally. Shutdown flushes
+// anything buffered and releases resources.
+type Exporter interface {
+	ExportSpan(span *SpanData)
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer creates spans and passes them to its exporter when they end.
+type Tracer struct {
+	exporter Exporter
+}
+
+// New returns a Tracer which exports finished spans to exporter.
+func New(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Shutdown flushes and shuts down the tracer's exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.exporter.Shutdown(ctx)
+}
+
+// Span is a single timed operation within a trace. A nil *Span is valid and does
+// nothing, so code can create spans unconditionally.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+
+	mu   sync.Mutex
+	data SpanData
+	done bool
+}
+
+type contextKey struct{}
+
+// Start begins a new span. If ctx already carries a span, the new span is its child;
+// otherwise, if ctx carries a remote parent (see ContextWithRemoteParent), the new span
+// continues that trace; failing both, a new trace is started.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	var parent SpanContext
+
+	switch v := ctx.Value(contextKey{}).(type) {
+	case *Span:
+		parent = v.sc
+	case SpanContext:
+		parent = v
+	}
+
+	sc := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+		sc.Sampled = true
+	}
+
+	span := &Span{
+		tracer: t,
+		sc:     sc,
+		data: SpanData{
+			TraceID:    sc.TraceID,
+			SpanID:     sc.SpanID,
+			ParentID:   parent.SpanID,
+			Name:       name,
+			Kind:       kind,
+			Start:      time.Now(),
+			Attributes: make(map[string]string),
+		},
+	}
+
+	return context.WithValue(ctx, contextKey{}, span), span
+}
+
+// StartChild begins a child of the span carried by ctx, using the same tracer. If ctx
+// doesn't carry a span, no span is created and the returned *Span is nil. This lets
+// lower layers (such as the data models) add detail to a trace without needing their
+// own reference to the tracer.
+func StartChild(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// ContextWithRemoteParent returns a copy of ctx carrying a span context received from
+// another service, so that the next span started from it joins the same trace.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil if there isn't one.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(contextKey{}).(*Span)
+	return span
+}
+
+// SpanContext returns the propagated identity of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName replaces the span name. This is useful when the best name isn't known until
+// after the span starts, such as the route pattern for an HTTP request.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute records a key/value attribute on the span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and, if it's sampled, passes it to the exporter. Calls after
+// the first have no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	s.data.End = time.Now()
+
+	data := s.data
+	data.Attributes = make(map[string]string, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.exporter.ExportSpan(&data)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,236 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		wantOK      bool
+		wantTraceID string
+		wantSpanID  string
+		wantSampled bool
+	}{
+		{
+			name:        "sampled",
+			value:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantOK:      true,
+			wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpanID:  "00f067aa0ba902b7",
+			wantSampled: true,
+		},
+		{
+			name:        "not sampled",
+			value:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			wantOK:      true,
+			wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpanID:  "00f067aa0ba902b7",
+		},
+		{
+			name:        "other flags set",
+			value:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03",
+			wantOK:      true,
+			wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpanID:  "00f067aa0ba902b7",
+			wantSampled: true,
+		},
+		{
+			name:        "surrounding whitespace",
+			value:       "  00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ",
+			wantOK:      true,
+			wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpanID:  "00f067aa0ba902b7",
+			wantSampled: true,
+		},
+		{
+			name:        "future version with extra fields",
+			value:       "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-will-be",
+			wantOK:      true,
+			wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantSpanID:  "00f067aa0ba902b7",
+			wantSampled: true,
+		},
+		{name: "empty", value: ""},
+		{name: "too few fields", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+		{name: "version ff", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "version too long", value: "000-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "version 00 with extra fields", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{name: "all-zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{name: "all-zero span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{name: "bad hex in trace id", value: "00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01"},
+		{name: "bad hex in span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01"},
+		{name: "bad hex in flags", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x"},
+		{name: "short trace id", value: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01"},
+		{name: "short span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01"},
+		{name: "long flags", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %t; want %t", ok, tt.wantOK)
+			}
+			if !ok {
+				if sc != (SpanContext{}) {
+					t.Errorf("got span context %+v for an invalid value; want the zero value", sc)
+				}
+				return
+			}
+
+			if got := sc.TraceID.String(); got != tt.wantTraceID {
+				t.Errorf("got trace id %q; want %q", got, tt.wantTraceID)
+			}
+			if got := sc.SpanID.String(); got != tt.wantSpanID {
+				t.Errorf("got span id %q; want %q", got, tt.wantSpanID)
+			}
+			if sc.Sampled != tt.wantSampled {
+				t.Errorf("got sampled %t; want %t", sc.Sampled, tt.wantSampled)
+			}
+		})
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	tests := []struct {
+		name string
+		sc   SpanContext
+		want string
+	}{
+		{
+			name: "sampled",
+			sc: SpanContext{
+				TraceID: TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+				SpanID:  SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+				Sampled: true,
+			},
+			want: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name: "not sampled",
+			sc: SpanContext{
+				TraceID: TraceID{15: 1},
+				SpanID:  SpanID{7: 1},
+			},
+			want: "00-00000000000000000000000000000001-0000000000000001-00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.sc.Traceparent()
+			if got != tt.want {
+				t.Fatalf("got %q; want %q", got, tt.want)
+			}
+
+			// Whatever we send must be accepted by our own parser.
+			sc, ok := ParseTraceparent(got)
+			if !ok || sc != tt.sc {
+				t.Errorf("parsed back as %+v, %t; want %+v, true", sc, ok, tt.sc)
+			}
+		})
+	}
+}
+
+// recordingExporter keeps every span exported to it.
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*SpanData
+}
+
+func (e *recordingExporter) ExportSpan(span *SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+func TestSpans(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := New(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root", KindServer)
+	_, child := StartChild(ctx, "child", KindClient)
+
+	child.SetAttribute("db.system", "postgresql")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	root.End()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("got %d spans exported; want 2", len(exporter.spans))
+	}
+
+	c, r := exporter.spans[0], exporter.spans[1]
+
+	if r.ParentID.IsValid() {
+		t.Errorf("got root parent %s; want none", r.ParentID)
+	}
+	if c.TraceID != r.TraceID {
+		t.Errorf("got child trace %s; want the root's %s", c.TraceID, r.TraceID)
+	}
+	if c.ParentID != r.SpanID {
+		t.Errorf("got child parent %s; want the root's span %s", c.ParentID, r.SpanID)
+	}
+	if c.Attributes["db.system"] != "postgresql" || c.Error != "boom" {
+		t.Errorf("got child attributes %v, error %q", c.Attributes, c.Error)
+	}
+	if c.End.Before(c.Start) {
+		t.Errorf("got child ending at %v before it started at %v", c.End, c.Start)
+	}
+}
+
+func TestSpansFromRemoteParent(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := New(exporter)
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteParent(context.Background(), parent)
+
+	_, span := tracer.Start(ctx, "request", KindServer)
+	span.End()
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("got %d spans exported; want 1", len(exporter.spans))
+	}
+	if got := exporter.spans[0]; got.TraceID != parent.TraceID || got.ParentID != parent.SpanID {
+		t.Errorf("got trace %s, parent %s; want %s, %s", got.TraceID, got.ParentID, parent.TraceID, parent.SpanID)
+	}
+
+	// An unsampled remote parent is honoured, so nothing is exported.
+	parent.Sampled = false
+	ctx = ContextWithRemoteParent(context.Background(), parent)
+
+	_, span = tracer.Start(ctx, "request", KindServer)
+	span.End()
+
+	if len(exporter.spans) != 1 {
+		t.Errorf("got %d spans exported; want the unsampled span dropped", len(exporter.spans))
+	}
+}
+
+func TestStartChildWithoutSpan(t *testing.T) {
+	ctx := context.Background()
+
+	got, span := StartChild(ctx, "query", KindClient)
+	if span != nil {
+		t.Fatal("got a span from a context without one")
+	}
+	if got != ctx {
+		t.Error("got a different context back")
+	}
+
+	// A nil span is safe to use.
+	span.SetName("x")
+	span.SetAttribute("k", "v")
+	span.RecordError(errors.New("boom"))
+	span.End()
+}
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL,
+    version integer NOT NULL,
+    action text NOT NULL,
+    changed_by text NOT NULL DEFAULT '',
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    diff jsonb NOT NULL DEFAULT '{}',
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_revisions_movie_id_version_idx ON movie_revisions (movie_id, version);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS rate_limit_windows (
+    key text NOT NULL,
+    window_index bigint NOT NULL,
+    count integer NOT NULL DEFAULT 0,
+    expires_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (key, window_index)
+);
+CREATE INDEX IF NOT EXISTS rate_limit_windows_expires_at_idx ON rate_limit_windows (expires_at);